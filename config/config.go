@@ -0,0 +1,319 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/iq"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/scm"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SOURCE_TYPE_AZURE            = "azure"
+	SOURCE_TYPE_BITBUCKET_CLOUD  = "bitbucket-cloud"
+	SOURCE_TYPE_BITBUCKET_SERVER = "bitbucket-server"
+	SOURCE_TYPE_GITHUB           = "github"
+	SOURCE_TYPE_GITLAB           = "gitlab"
+	SOURCE_TYPE_MANIFEST         = "manifest"
+)
+
+var (
+	SOURCE_TYPES  = []string{SOURCE_TYPE_AZURE, SOURCE_TYPE_BITBUCKET_CLOUD, SOURCE_TYPE_BITBUCKET_SERVER, SOURCE_TYPE_GITHUB, SOURCE_TYPE_GITLAB, SOURCE_TYPE_MANIFEST}
+	ENV_REFERENCE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// Config holds the settings for a run, as read from a YAML or JSON configuration file. Any string value
+// may refer to an Environment Variable as ${NAME}.
+type Config struct {
+	Iq Iq `json:"iq" yaml:"iq"`
+	// Filters apply to every source, in addition to any the source has of its own
+	Filters scm.Filters `json:"filters" yaml:"filters"`
+	// Naming applies to every source, unless the source sets its own templates
+	Naming  scm.Naming `json:"naming" yaml:"naming"`
+	Sources []Source   `json:"sources" yaml:"sources"`
+}
+
+type Iq struct {
+	Url      string `json:"url" yaml:"url"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	// Organization is the name of the Organization to import into, unless a source names its own
+	Organization string `json:"organization" yaml:"organization"`
+	MatchBy      string `json:"matchBy" yaml:"matchBy"`
+	// PublicIdStrategy is how the public IDs of new Applications are chosen
+	PublicIdStrategy string `json:"publicIdStrategy" yaml:"publicIdStrategy"`
+	Concurrency      int    `json:"concurrency" yaml:"concurrency"`
+	MaxAttempts      int    `json:"maxAttempts" yaml:"maxAttempts"`
+	ContinueOnError  bool   `json:"continueOnError" yaml:"continueOnError"`
+}
+
+// Source is an SCM to load Organizations and Applications from.
+type Source struct {
+	// Name is how the source is referred to in output - defaults to the name of its type of SCM
+	Name     string `json:"name" yaml:"name"`
+	Type     string `json:"type" yaml:"type"`
+	Url      string `json:"url" yaml:"url"`
+	Username string `json:"username" yaml:"username"`
+	Token    string `json:"token" yaml:"token"`
+	// Path is that of the YAML, JSON or CSV file a manifest source is read from
+	Path string `json:"path" yaml:"path"`
+	// Workspace limits a Bitbucket Cloud source to a single Workspace
+	Workspace string `json:"workspace" yaml:"workspace"`
+	// IncludeArchived includes archived GitLab Projects
+	IncludeArchived bool `json:"includeArchived" yaml:"includeArchived"`
+	// Collections are the URLs of Azure DevOps Server (on-premises) Project Collections to load, instead of
+	// the Azure DevOps Services Organizations the token can see
+	Collections []string `json:"collections" yaml:"collections"`
+	// AzureOrganizations names (or gives the URLs of) the Azure DevOps Organizations to load, instead of
+	// every Organization the token can see
+	AzureOrganizations []string `json:"azureOrganizations" yaml:"azureOrganizations"`
+	// IncludeDisabled, IncludeEmpty and IncludeForks include Azure DevOps Repositories that are disabled,
+	// have no Default Branch or are forks - all skipped by default
+	IncludeDisabled bool `json:"includeDisabled" yaml:"includeDisabled"`
+	IncludeEmpty    bool `json:"includeEmpty" yaml:"includeEmpty"`
+	IncludeForks    bool `json:"includeForks" yaml:"includeForks"`
+	// Concurrency is the number of requests made to Azure DevOps in parallel
+	Concurrency int `json:"concurrency" yaml:"concurrency"`
+	// Organization is the name of the Organization to import this source into
+	Organization string `json:"organization" yaml:"organization"`
+	// ApplyScmConfiguration controls whether SCM configuration is set on Organizations - defaults to true
+	ApplyScmConfiguration *bool       `json:"applyScmConfiguration" yaml:"applyScmConfiguration"`
+	Filters               scm.Filters `json:"filters" yaml:"filters"`
+	Naming                scm.Naming  `json:"naming" yaml:"naming"`
+}
+
+// Load reads, interpolates and validates the configuration file at path. Files ending .json are read as
+// JSON - anything else as YAML.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %v", err)
+	}
+
+	cfg := &Config{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+	}
+	if err == io.EOF {
+		return nil, fmt.Errorf("configuration file %s is empty", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file %s: %v", path, err)
+	}
+
+	problems := expandEnv(reflect.ValueOf(cfg).Elem(), "")
+	problems = append(problems, cfg.Validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("configuration file %s is not valid:\n  - %s", path, strings.Join(problems, "\n  - "))
+	}
+	return cfg, nil
+}
+
+// Validate returns a description of each problem with the configuration, naming the setting at fault.
+func (c *Config) Validate() []string {
+	problems := make([]string, 0)
+
+	if c.Iq.Url != "" && !isHttpUrl(c.Iq.Url) {
+		problems = append(problems, fmt.Sprintf("iq.url: '%s' is not an http:// or https:// URL", c.Iq.Url))
+	}
+	if c.Iq.MatchBy != "" && c.Iq.MatchBy != iq.APPLICATION_MATCH_BY_NAME && c.Iq.MatchBy != iq.APPLICATION_MATCH_BY_REPOSITORY_URL {
+		problems = append(problems, fmt.Sprintf("iq.matchBy: must be %s or %s", iq.APPLICATION_MATCH_BY_NAME, iq.APPLICATION_MATCH_BY_REPOSITORY_URL))
+	}
+	if c.Iq.PublicIdStrategy != "" && c.Iq.PublicIdStrategy != iq.PUBLIC_ID_STRATEGY_NAME && c.Iq.PublicIdStrategy != iq.PUBLIC_ID_STRATEGY_SCM_IDENTITY {
+		problems = append(problems, fmt.Sprintf("iq.publicIdStrategy: must be %s or %s", iq.PUBLIC_ID_STRATEGY_NAME, iq.PUBLIC_ID_STRATEGY_SCM_IDENTITY))
+	}
+	if c.Iq.Concurrency < 0 {
+		problems = append(problems, "iq.concurrency: must be at least 1")
+	}
+	if c.Iq.MaxAttempts < 0 {
+		problems = append(problems, "iq.maxAttempts: must be at least 1")
+	}
+	problems = append(problems, prefixed("filters.", c.Filters.Validate())...)
+	problems = append(problems, prefixed("naming.", c.Naming.Validate())...)
+
+	for i, s := range c.Sources {
+		problems = append(problems, prefixed(fmt.Sprintf("sources[%d].", i), s.Validate())...)
+	}
+	return problems
+}
+
+// Validate returns a description of each problem with the source, naming the setting at fault.
+func (s *Source) Validate() []string {
+	problems := make([]string, 0)
+
+	known := false
+	for _, t := range SOURCE_TYPES {
+		known = known || s.Type == t
+	}
+	if !known {
+		problems = append(problems, fmt.Sprintf("type: must be one of %s", strings.Join(SOURCE_TYPES, ", ")))
+	}
+
+	if s.Url != "" && !isHttpUrl(s.Url) {
+		problems = append(problems, fmt.Sprintf("url: '%s' is not an http:// or https:// URL", s.Url))
+	}
+	if s.Type == SOURCE_TYPE_BITBUCKET_SERVER && s.Url == "" {
+		problems = append(problems, "url: must be set for a bitbucket-server source")
+	}
+	if s.Type == SOURCE_TYPE_MANIFEST && s.Path == "" {
+		problems = append(problems, "path: must be set for a manifest source")
+	}
+	if s.Path != "" && s.Type != SOURCE_TYPE_MANIFEST {
+		problems = append(problems, "path: only applies to manifest sources")
+	}
+	if s.Url != "" && s.Type == SOURCE_TYPE_MANIFEST {
+		problems = append(problems, "url: does not apply to manifest sources")
+	}
+	if s.Workspace != "" && s.Type != SOURCE_TYPE_BITBUCKET_CLOUD {
+		problems = append(problems, "workspace: only applies to bitbucket-cloud sources")
+	}
+	if s.IncludeArchived && s.Type != SOURCE_TYPE_GITLAB {
+		problems = append(problems, "includeArchived: only applies to gitlab sources")
+	}
+	if len(s.Collections) > 0 && s.Type != SOURCE_TYPE_AZURE {
+		problems = append(problems, "collections: only applies to azure sources")
+	}
+	for i, c := range s.Collections {
+		if !isHttpUrl(c) {
+			problems = append(problems, fmt.Sprintf("collections[%d]: '%s' is not an http:// or https:// URL", i, c))
+		}
+	}
+	if len(s.AzureOrganizations) > 0 && s.Type != SOURCE_TYPE_AZURE {
+		problems = append(problems, "azureOrganizations: only applies to azure sources")
+	}
+	if len(s.AzureOrganizations) > 0 && len(s.Collections) > 0 {
+		problems = append(problems, "azureOrganizations: cannot be used with collections")
+	}
+	if (s.IncludeDisabled || s.IncludeEmpty || s.IncludeForks) && s.Type != SOURCE_TYPE_AZURE {
+		problems = append(problems, "includeDisabled, includeEmpty and includeForks: only apply to azure sources")
+	}
+	if s.Concurrency != 0 && s.Type != SOURCE_TYPE_AZURE {
+		problems = append(problems, "concurrency: only applies to azure sources")
+	}
+	if s.Concurrency < 0 {
+		problems = append(problems, "concurrency: must be at least 1")
+	}
+	problems = append(problems, prefixed("filters.", s.Filters.Validate())...)
+	problems = append(problems, prefixed("naming.", s.Naming.Validate())...)
+
+	return problems
+}
+
+// AppliesScmConfiguration reports whether SCM configuration is to be set on the Organizations this
+// source creates.
+func (s *Source) AppliesScmConfiguration() bool {
+	return s.ApplyScmConfiguration == nil || *s.ApplyScmConfiguration
+}
+
+// DisplayName is how the source is referred to in output.
+func (s *Source) DisplayName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	switch s.Type {
+	case SOURCE_TYPE_AZURE:
+		return "Azure DevOps"
+	case SOURCE_TYPE_BITBUCKET_CLOUD:
+		return "Bitbucket Cloud"
+	case SOURCE_TYPE_BITBUCKET_SERVER:
+		return "Bitbucket Server"
+	case SOURCE_TYPE_GITHUB:
+		return "GitHub"
+	case SOURCE_TYPE_GITLAB:
+		return "GitLab"
+	case SOURCE_TYPE_MANIFEST:
+		return fmt.Sprintf("Manifest %s", s.Path)
+	}
+	return s.Type
+}
+
+// ScmProvider is the type of SCM configuration Sonatype Lifecycle holds for this source - unknown for a
+// manifest source until the manifest is read.
+func (s *Source) ScmProvider() string {
+	switch s.Type {
+	case SOURCE_TYPE_BITBUCKET_CLOUD, SOURCE_TYPE_BITBUCKET_SERVER:
+		return scm.SCM_TYPE_BITBUCKET
+	case SOURCE_TYPE_MANIFEST:
+		return ""
+	}
+	return s.Type
+}
+
+// expandEnv replaces ${NAME} in every string within v with the value of the Environment Variable NAME,
+// returning a problem for each that is not set.
+func expandEnv(v reflect.Value, label string) []string {
+	problems := make([]string, 0)
+	switch v.Kind() {
+	case reflect.String:
+		expanded := ENV_REFERENCE.ReplaceAllStringFunc(v.String(), func(reference string) string {
+			name := ENV_REFERENCE.FindStringSubmatch(reference)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: Environment Variable %s is not set", label, name))
+			}
+			return value
+		})
+		v.SetString(expanded)
+	case reflect.Pointer:
+		if !v.IsNil() {
+			problems = append(problems, expandEnv(v.Elem(), label)...)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			problems = append(problems, expandEnv(v.Index(i), fmt.Sprintf("%s[%d]", label, i))...)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			if label != "" {
+				name = label + "." + name
+			}
+			problems = append(problems, expandEnv(v.Field(i), name)...)
+		}
+	}
+	return problems
+}
+
+func isHttpUrl(in string) bool {
+	u, err := url.Parse(in)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func prefixed(prefix string, problems []string) []string {
+	for i := range problems {
+		problems[i] = prefix + problems[i]
+	}
+	return problems
+}
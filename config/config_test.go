@@ -0,0 +1,153 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadYaml(t *testing.T) {
+	t.Setenv("TEST_IQ_PASSWORD", "s3cret")
+	t.Setenv("TEST_ADO_PAT", "pat")
+
+	cfg, err := Load(writeConfig(t, "onboarder.yaml", `
+iq:
+  url: https://iq.example.com
+  username: admin
+  password: ${TEST_IQ_PASSWORD}
+  organization: Imported
+  matchBy: repository-url
+filters:
+  exclude:
+    - repository: "*-archive"
+sources:
+  - type: azure
+    token: ${TEST_ADO_PAT}
+    concurrency: 4
+    includeForks: true
+    collections:
+      - https://tfs.example.com/tfs/DefaultCollection
+    applyScmConfiguration: false
+    filters:
+      exclude:
+        - organization: sandbox*
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://iq.example.com", cfg.Iq.Url)
+	assert.Equal(t, "s3cret", cfg.Iq.Password)
+	assert.Equal(t, "Imported", cfg.Iq.Organization)
+	assert.Equal(t, "*-archive", cfg.Filters.Exclude[0].Repository)
+	assert.Len(t, cfg.Sources, 1)
+	assert.Equal(t, "pat", cfg.Sources[0].Token)
+	assert.Equal(t, 4, cfg.Sources[0].Concurrency)
+	assert.True(t, cfg.Sources[0].IncludeForks)
+	assert.Equal(t, []string{"https://tfs.example.com/tfs/DefaultCollection"}, cfg.Sources[0].Collections)
+	assert.False(t, cfg.Sources[0].IncludeEmpty)
+	assert.False(t, cfg.Sources[0].AppliesScmConfiguration())
+	assert.Equal(t, "sandbox*", cfg.Sources[0].Filters.Exclude[0].Organization)
+}
+
+func TestLoadJson(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "onboarder.json", `{
+  "iq": {"url": "http://localhost:8070"},
+  "sources": [
+    {"type": "gitlab", "url": "https://gitlab.example.com/api/v4", "includeArchived": true},
+    {"type": "azure", "azureOrganizations": ["acme", "https://dev.azure.com/widgets"]},
+    {"type": "manifest", "path": "inventory.csv"}
+  ]
+}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8070", cfg.Iq.Url)
+	assert.True(t, cfg.Sources[0].IncludeArchived)
+	assert.True(t, cfg.Sources[0].AppliesScmConfiguration())
+	assert.Equal(t, "GitLab", cfg.Sources[0].DisplayName())
+	assert.Equal(t, []string{"acme", "https://dev.azure.com/widgets"}, cfg.Sources[1].AzureOrganizations)
+	assert.Equal(t, "Manifest inventory.csv", cfg.Sources[2].DisplayName())
+	assert.Empty(t, cfg.Sources[2].ScmProvider())
+}
+
+func TestLoadInvalid(t *testing.T) {
+	_, err := Load(writeConfig(t, "onboarder.yaml", `
+iq:
+  url: iq.example.com
+  password: ${TEST_UNSET_VARIABLE}
+  matchBy: id
+  publicIdStrategy: guid
+naming:
+  applicationId: "{{.Repo"
+sources:
+  - type: svn
+  - type: manifest
+  - type: bitbucket-server
+    path: inventory.csv
+    workspace: acme
+    includeForks: true
+    collections: [tfs.example.com]
+    azureOrganizations: [acme]
+    filters:
+      include:
+        - {}
+        - repository: "[abc"
+        - repositoryUrl: "re:https://(.*"
+`))
+	assert.Error(t, err)
+	for _, problem := range []string{
+		"iq.password: Environment Variable TEST_UNSET_VARIABLE is not set",
+		"iq.url: 'iq.example.com' is not an http:// or https:// URL",
+		"iq.matchBy: must be name or repository-url",
+		"iq.publicIdStrategy: must be name or scm-identity",
+		"naming.applicationId: malformed template '{{.Repo'",
+		"sources[0].type: must be one of azure, bitbucket-cloud, bitbucket-server, github, gitlab, manifest",
+		"sources[1].path: must be set for a manifest source",
+		"sources[2].path: only applies to manifest sources",
+		"sources[2].url: must be set for a bitbucket-server source",
+		"sources[2].workspace: only applies to bitbucket-cloud sources",
+		"sources[2].collections: only applies to azure sources",
+		"sources[2].collections[0]: 'tfs.example.com' is not an http:// or https:// URL",
+		"sources[2].azureOrganizations: only applies to azure sources",
+		"sources[2].azureOrganizations: cannot be used with collections",
+		"sources[2].includeDisabled, includeEmpty and includeForks: only apply to azure sources",
+		"sources[2].filters.include[0]: at least one of account, project, organization, repository or repositoryUrl must be set",
+		"sources[2].filters.include[1].repository: malformed pattern '[abc'",
+		"sources[2].filters.include[2].repositoryUrl: malformed pattern 're:https://(.*'",
+	} {
+		assert.Contains(t, err.Error(), problem)
+	}
+}
+
+func TestLoadUnknownSetting(t *testing.T) {
+	_, err := Load(writeConfig(t, "onboarder.yaml", "iq:\n  uri: https://iq.example.com\n"))
+	assert.ErrorContains(t, err, "field uri not found")
+
+	_, err = Load(writeConfig(t, "onboarder.json", `{"iq": {"uri": "https://iq.example.com"}}`))
+	assert.ErrorContains(t, err, `unknown field "uri"`)
+}
+
+func TestLoadEmpty(t *testing.T) {
+	_, err := Load(writeConfig(t, "onboarder.yaml", ""))
+	assert.ErrorContains(t, err, "is empty")
+}
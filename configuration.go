@@ -0,0 +1,210 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/config"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/iq"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/scm"
+)
+
+/**
+ * Loads the -config file (if any) and applies its settings to those flags that were not set on the
+ * command line, returning the sources to load from.
+ */
+func loadConfiguration() ([]config.Source, error) {
+	cfg := &config.Config{}
+	if configFile != "" {
+		var err error
+		cfg, err = config.Load(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	overrideString := func(name string, target *string, value string) {
+		if !setFlags[name] && value != "" {
+			*target = value
+		}
+	}
+	overrideInt := func(name string, target *int, value int) {
+		if !setFlags[name] && value != 0 {
+			*target = value
+		}
+	}
+	overrideString("url", &nxiqUrl, cfg.Iq.Url)
+	overrideString("username", &nxiqUsername, cfg.Iq.Username)
+	overrideString("password", &nxiqPassword, cfg.Iq.Password)
+	overrideString("org-name", &nxiqOrgNameToImportTo, cfg.Iq.Organization)
+	overrideString("match-by", &matchBy, cfg.Iq.MatchBy)
+	overrideString("public-id-strategy", &publicIdStrategy, cfg.Iq.PublicIdStrategy)
+	overrideInt("concurrency", &concurrency, cfg.Iq.Concurrency)
+	overrideInt("max-attempts", &maxAttempts, cfg.Iq.MaxAttempts)
+	if !setFlags["continue-on-error"] && cfg.Iq.ContinueOnError {
+		continueOnError = true
+	}
+
+	// Filter rules given on the command line apply in addition to those in the configuration file
+	cfg.Filters = cfg.Filters.Merge(filters)
+	if problems := naming.Validate(); len(problems) > 0 {
+		return nil, fmt.Errorf("invalid naming template - %s", strings.Join(problems, "; "))
+	}
+
+	sources := sourcesToLoad(cfg, setFlags)
+	switch publicIdStrategy {
+	case iq.PUBLIC_ID_STRATEGY_NAME:
+	case iq.PUBLIC_ID_STRATEGY_SCM_IDENTITY:
+		for _, s := range sources {
+			if s.Naming.ApplicationId != "" {
+				return nil, fmt.Errorf("an Application ID template cannot be used with -public-id-strategy %s (%s)", iq.PUBLIC_ID_STRATEGY_SCM_IDENTITY, s.DisplayName())
+			}
+		}
+	default:
+		return nil, fmt.Errorf("-public-id-strategy must be %s or %s", iq.PUBLIC_ID_STRATEGY_NAME, iq.PUBLIC_ID_STRATEGY_SCM_IDENTITY)
+	}
+	return sources, nil
+}
+
+/**
+ * Works out the sources to load from: those in the configuration file, unless a source is selected on the
+ * command line (e.g. -azure), in which case just that one - taking its settings from the configuration
+ * file where it has a source of the same type. Source settings given on the command line take precedence.
+ */
+func sourcesToLoad(cfg *config.Config, setFlags map[string]bool) []config.Source {
+	sources := cfg.Sources
+
+	var selected string
+	switch {
+	case azureScm:
+		selected = config.SOURCE_TYPE_AZURE
+	case bitbucketCloudScm:
+		selected = config.SOURCE_TYPE_BITBUCKET_CLOUD
+	case bitbucketServerScm:
+		selected = config.SOURCE_TYPE_BITBUCKET_SERVER
+	case githubScm:
+		selected = config.SOURCE_TYPE_GITHUB
+	case gitlabScm:
+		selected = config.SOURCE_TYPE_GITLAB
+	case manifestFile != "":
+		selected = config.SOURCE_TYPE_MANIFEST
+	}
+	if selected != "" {
+		source := config.Source{Type: selected}
+		for _, s := range cfg.Sources {
+			if s.Type == selected {
+				source = s
+				break
+			}
+		}
+		sources = []config.Source{source}
+	}
+
+	for i := range sources {
+		s := &sources[i]
+		switch s.Type {
+		case config.SOURCE_TYPE_AZURE:
+			if setFlags["azure-collection"] {
+				s.Collections = azureCollections
+			}
+			if setFlags["azure-organization"] {
+				s.AzureOrganizations = azureOrganizations
+			}
+			if setFlags["azure-concurrency"] || s.Concurrency == 0 {
+				s.Concurrency = azureConcurrency
+			}
+			if setFlags["azure-include-disabled"] {
+				s.IncludeDisabled = azureIncludeDisabled
+			}
+			if setFlags["azure-include-empty"] {
+				s.IncludeEmpty = azureIncludeEmpty
+			}
+			if setFlags["azure-include-forks"] {
+				s.IncludeForks = azureIncludeForks
+			}
+		case config.SOURCE_TYPE_BITBUCKET_CLOUD:
+			if setFlags["bitbucket-cloud-workspace"] || s.Workspace == "" {
+				s.Workspace = bitbucketCloudWorkspace
+			}
+		case config.SOURCE_TYPE_BITBUCKET_SERVER:
+			if setFlags["bitbucket-server-url"] || s.Url == "" {
+				s.Url = bitbucketServerUrl
+			}
+		case config.SOURCE_TYPE_GITHUB:
+			if setFlags["github-url"] || s.Url == "" {
+				s.Url = githubUrl
+			}
+		case config.SOURCE_TYPE_GITLAB:
+			if setFlags["gitlab-url"] || s.Url == "" {
+				s.Url = gitlabUrl
+			}
+			if setFlags["gitlab-include-archived"] {
+				s.IncludeArchived = gitlabIncludeArchived
+			}
+		case config.SOURCE_TYPE_MANIFEST:
+			if setFlags["manifest"] || s.Path == "" {
+				s.Path = manifestFile
+			}
+		}
+		if setFlags["org-name"] || s.Organization == "" {
+			s.Organization = nxiqOrgNameToImportTo
+		}
+		s.Filters = cfg.Filters.Merge(s.Filters)
+		// Naming templates given on the command line take precedence over those of the source
+		s.Naming = cfg.Naming.Merge(s.Naming).Merge(naming)
+	}
+	return sources
+}
+
+// parseFilterRule parses a -include or -exclude rule given as comma separated field=pattern pairs.
+func parseFilterRule(value string) (scm.FilterRule, error) {
+	rule := scm.FilterRule{}
+	for _, part := range strings.Split(value, ",") {
+		field, pattern, ok := strings.Cut(part, "=")
+		if !ok || pattern == "" {
+			return rule, fmt.Errorf("expected field=pattern but got '%s'", part)
+		}
+		switch strings.TrimSpace(field) {
+		case "account":
+			rule.Account = pattern
+		case "project":
+			rule.Project = pattern
+		case "organization":
+			rule.Organization = pattern
+		case "repository":
+			rule.Repository = pattern
+		case "repositoryUrl":
+			rule.RepositoryUrl = pattern
+		default:
+			return rule, fmt.Errorf("unknown field '%s' - expected account, project, organization, repository or repositoryUrl", field)
+		}
+	}
+
+	if problems := (&scm.Filters{Include: []scm.FilterRule{rule}}).Validate(); len(problems) > 0 {
+		return rule, errors.New(strings.TrimPrefix(problems[0], "include[0]."))
+	}
+	return rule, nil
+}
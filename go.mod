@@ -4,15 +4,17 @@ go 1.22.0
 
 toolchain go1.22.8
 
-require github.com/sirupsen/logrus v1.9.3
+require (
+	github.com/google/uuid v1.1.1
+	github.com/sirupsen/logrus v1.9.3
+	github.com/stretchr/testify v1.10.0
+	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/google/uuid v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
-	golang.org/x/term v0.27.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
@@ -0,0 +1,155 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/**
+ * A record of one Organization or Application that was created or updated in Sonatype Lifecycle,
+ * keyed by its identity in the SCM (see scmKey()).
+ */
+type JournalEntry struct {
+	Key           string    `json:"key"`
+	Entity        string    `json:"entity"`
+	Action        string    `json:"action"`
+	IqId          string    `json:"iqId"`
+	Name          string    `json:"name"`
+	PublicId      string    `json:"publicId,omitempty"`
+	RepositoryUrl string    `json:"repositoryUrl,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+/**
+ * An append-only, line-delimited JSON log of everything a run has done, flushed after every
+ * entry so that a failed run can be resumed from exactly where it stopped.
+ *
+ * All methods are safe to call on a nil *Journal, which records nothing, and from multiple goroutines.
+ */
+type Journal struct {
+	path      string
+	file      *os.File
+	completed map[string]JournalEntry
+	mu        sync.Mutex
+}
+
+/**
+ * Opens the journal at `path`. When `resume` is true, the entries already in the journal are
+ * loaded and new entries are appended - otherwise any existing journal is replaced.
+ */
+func OpenJournal(path string, resume bool) (*Journal, error) {
+	j := &Journal{
+		path:      path,
+		completed: make(map[string]JournalEntry),
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		err := j.load()
+		if err != nil {
+			return nil, err
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		log.Info(fmt.Sprintf("Resuming from journal %s - %d Organizations and Applications already complete", path, len(j.completed)))
+	}
+
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+
+	return j, nil
+}
+
+func (j *Journal) load() error {
+	file, err := os.Open(j.path)
+	if err != nil {
+		return fmt.Errorf("cannot resume - failed to open journal: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			// A partially written final line is expected if the previous run was killed mid-write
+			log.Warn(fmt.Sprintf("Ignoring unreadable line %d of journal %s: %v", line, j.path, err))
+			continue
+		}
+		j.completed[entry.Key] = entry
+	}
+
+	return scanner.Err()
+}
+
+// Completed returns the journal entry for the given SCM key if a previous run already completed it.
+func (j *Journal) Completed(key string) *JournalEntry {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.completed[key]
+	if !ok {
+		return nil
+	}
+	return &entry
+}
+
+func (j *Journal) Record(entry JournalEntry) error {
+	if j == nil {
+		return nil
+	}
+
+	entry.Timestamp = time.Now().UTC()
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err = j.file.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write to journal %s: %v", j.path, err)
+	}
+	j.completed[entry.Key] = entry
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,68 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	journal, err := OpenJournal(path, false)
+	assert.NoError(t, err)
+	assert.NoError(t, journal.Record(JournalEntry{Key: "azure:/acme", Entity: PLAN_ENTITY_ORGANIZATION, Action: PLAN_ACTION_CREATE, IqId: "org-1", Name: "acme"}))
+	assert.NoError(t, journal.Record(JournalEntry{Key: "azure:/acme/repo", Entity: PLAN_ENTITY_APPLICATION, Action: PLAN_ACTION_CREATE, IqId: "app-1", Name: "repo", PublicId: "repo"}))
+	assert.NoError(t, journal.Close())
+
+	// Simulate the previous run being killed part way through writing an entry
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"key":"azure:/acme/other","ent`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	resumed, err := OpenJournal(path, true)
+	assert.NoError(t, err)
+	defer resumed.Close()
+
+	assert.Equal(t, "org-1", resumed.Completed("azure:/acme").IqId)
+	assert.Equal(t, "repo", resumed.Completed("azure:/acme/repo").PublicId)
+	assert.Nil(t, resumed.Completed("azure:/acme/other"))
+
+	// Starting afresh discards the previous journal
+	fresh, err := OpenJournal(path, false)
+	assert.NoError(t, err)
+	defer fresh.Close()
+	assert.Nil(t, fresh.Completed("azure:/acme"))
+}
+
+func TestNilJournal(t *testing.T) {
+	var journal *Journal
+	assert.Nil(t, journal.Completed("azure:/acme"))
+	assert.NoError(t, journal.Record(JournalEntry{Key: "azure:/acme"}))
+	assert.NoError(t, journal.Close())
+}
+
+func TestResumeWithoutJournal(t *testing.T) {
+	_, err := OpenJournal(filepath.Join(t.TempDir(), "missing.jsonl"), true)
+	assert.Error(t, err)
+}
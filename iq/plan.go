@@ -0,0 +1,443 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	sonatypeiq "github.com/sonatype-nexus-community/nexus-iq-api-client-go"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/scm"
+)
+
+const (
+	PLAN_FORMAT_VERSION      = 1
+	PLAN_ACTION_CREATE       = "create"
+	PLAN_ACTION_UPDATE       = "update"
+	PLAN_ACTION_SKIP         = "skip"
+	PLAN_ACTION_OMIT         = "omit"
+	PLAN_ENTITY_ORGANIZATION = "organization"
+	PLAN_ENTITY_APPLICATION  = "application"
+)
+
+/**
+ * A single operation that applying a Plan will perform in Sonatype Lifecycle.
+ *
+ * Entries reference their parent Organization by the `Key` of an earlier Organization entry (`ParentKey`,
+ * empty for top level Organizations) and, where the parent already exists in IQ, by its ID (`ParentId`).
+ *
+ * An existing Application matched by Repository URL in a different Organization records that
+ * Organization as `CurrentParentId` - it is updated where it is, not moved.
+ *
+ * Repositories the SCM integration left out (e.g. disabled or empty ones) are recorded with the action
+ * `omit` and the reason, so the plan shows why - applying the Plan does nothing for them.
+ */
+type PlanEntry struct {
+	Key                   string  `json:"key"`
+	Entity                string  `json:"entity"`
+	Action                string  `json:"action"`
+	ParentKey             string  `json:"parentKey,omitempty"`
+	ParentId              string  `json:"parentId,omitempty"`
+	ScmName               string  `json:"scmName"`
+	Name                  string  `json:"name"`
+	PublicId              string  `json:"publicId,omitempty"`
+	ExistingId            string  `json:"existingId,omitempty"`
+	CurrentParentId       string  `json:"currentParentId,omitempty"`
+	RepositoryUrl         string  `json:"repositoryUrl,omitempty"`
+	DefaultBranch         *string `json:"defaultBranch,omitempty"`
+	ScmProvider           string  `json:"scmProvider,omitempty"`
+	ScmUsername           string  `json:"scmUsername,omitempty"`
+	ApplyScmConfiguration bool    `json:"applyScmConfiguration"`
+	Reason                string  `json:"reason,omitempty"`
+}
+
+type Plan struct {
+	FormatVersion            int         `json:"formatVersion"`
+	CreatedAt                time.Time   `json:"createdAt"`
+	IqUrl                    string      `json:"iqUrl"`
+	ApplicationMatchStrategy string      `json:"applicationMatchStrategy,omitempty"`
+	PublicIdStrategy         string      `json:"publicIdStrategy,omitempty"`
+	Entries                  []PlanEntry `json:"entries"`
+}
+
+func (p *Plan) Count(action string) int {
+	count := 0
+	for _, e := range p.Entries {
+		if e.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// HasChanges reports whether applying the plan would create or update anything.
+func (p *Plan) HasChanges() bool {
+	return p.Count(PLAN_ACTION_CREATE) > 0 || p.Count(PLAN_ACTION_UPDATE) > 0
+}
+
+func (p *Plan) Print() {
+	depths := make(map[string]int)
+	for _, e := range p.Entries {
+		depth := 0
+		if e.ParentKey != "" {
+			depth = depths[e.ParentKey] + 1
+		}
+		if e.Entity == PLAN_ENTITY_ORGANIZATION {
+			depths[e.Key] = depth
+		}
+
+		println(fmt.Sprintf("%s%s %s", strings.Repeat("    ", depth), planActionSymbol(e.Action), e.describe()))
+	}
+	println("")
+	println(fmt.Sprintf("Plan: %d to create, %d to update, %d to skip, %d left out.", p.Count(PLAN_ACTION_CREATE), p.Count(PLAN_ACTION_UPDATE), p.Count(PLAN_ACTION_SKIP), p.Count(PLAN_ACTION_OMIT)))
+}
+
+func (p *Plan) Save(path string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+func (e *PlanEntry) describe() string {
+	var b strings.Builder
+	if e.Entity == PLAN_ENTITY_ORGANIZATION {
+		fmt.Fprintf(&b, "ORG: %s", e.ScmName)
+	} else {
+		fmt.Fprintf(&b, "APP: %s", e.ScmName)
+	}
+
+	switch e.Action {
+	case PLAN_ACTION_CREATE:
+		fmt.Fprintf(&b, " (create as %s", e.Name)
+		if e.PublicId != "" {
+			fmt.Fprintf(&b, " with ID %s", e.PublicId)
+		}
+		b.WriteString(")")
+	case PLAN_ACTION_OMIT:
+		b.WriteString(" (not onboarded)")
+	default:
+		fmt.Fprintf(&b, " (existing %s - %s)", e.Name, e.ExistingId)
+	}
+
+	if e.ApplyScmConfiguration && e.Action != PLAN_ACTION_OMIT {
+		if e.Entity == PLAN_ENTITY_ORGANIZATION {
+			fmt.Fprintf(&b, " [%s SCM configuration]", e.ScmProvider)
+		} else if e.DefaultBranch != nil {
+			fmt.Fprintf(&b, " [source control: %s @ %s]", e.RepositoryUrl, *e.DefaultBranch)
+		} else {
+			fmt.Fprintf(&b, " [source control: %s]", e.RepositoryUrl)
+		}
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&b, " - %s", e.Reason)
+	}
+	return b.String()
+}
+
+func planActionSymbol(action string) string {
+	switch action {
+	case PLAN_ACTION_CREATE:
+		return "+"
+	case PLAN_ACTION_UPDATE:
+		return "~"
+	case PLAN_ACTION_OMIT:
+		return "-"
+	default:
+		return "!"
+	}
+}
+
+/**
+ * Works out what ApplyOrgContents would do, using only the cached state of Sonatype Lifecycle -
+ * nothing is written.
+ */
+func (s *NxiqServer) PlanOrgContents(orgContent scm.OrgContents, rootOrganization *sonatypeiq.ApiOrganizationDTO, scmConfig *scm.ScmConfiguration) (*Plan, error) {
+	return s.PlanSources([]ImportSource{{OrgContents: orgContent, RootOrganization: rootOrganization, ScmConfig: scmConfig}})
+}
+
+// PlanSources works out what ApplySources would do, as a single Plan covering every source.
+func (s *NxiqServer) PlanSources(sources []ImportSource) (*Plan, error) {
+	err := checkSourcesDistinct(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.InitCache()
+	if err != nil {
+		return nil, err
+	}
+
+	planner := &planner{
+		server:           s,
+		plannedOrgNames:  make(map[string]bool),
+		plannedAppNames:  make(map[string]bool),
+		plannedPublicIds: make(map[string]bool),
+	}
+	for _, source := range sources {
+		planner.scmConfig = source.ScmConfig
+		for _, o := range source.OrgContents.Organizations {
+			err = planner.planOrganization(o, "", *source.RootOrganization.Id)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Plan{
+		FormatVersion:            PLAN_FORMAT_VERSION,
+		CreatedAt:                time.Now().UTC(),
+		IqUrl:                    s.baseUrl,
+		ApplicationMatchStrategy: s.applicationMatchStrategy,
+		PublicIdStrategy:         s.publicIdStrategy,
+		Entries:                  planner.entries,
+	}, nil
+}
+
+type planner struct {
+	server *NxiqServer
+	// scmConfig is that of the source being planned
+	scmConfig        *scm.ScmConfiguration
+	entries          []PlanEntry
+	plannedOrgNames  map[string]bool
+	plannedAppNames  map[string]bool
+	plannedPublicIds map[string]bool
+}
+
+// planOrganization plans an Organization and everything beneath it. parentId is empty when the
+// parent Organization is itself yet to be created.
+func (p *planner) planOrganization(o scm.Organization, parentKey string, parentId string) error {
+	entry := PlanEntry{
+		Key:                   scmKey(parentKey, o.ScmProvider, o.Name),
+		Entity:                PLAN_ENTITY_ORGANIZATION,
+		ParentKey:             parentKey,
+		ParentId:              parentId,
+		ScmName:               o.Name,
+		ScmProvider:           o.ScmProvider,
+		ApplyScmConfiguration: o.ApplyScmConfiguration && p.scmConfig != nil,
+	}
+	if entry.ApplyScmConfiguration {
+		entry.ScmProvider = p.scmConfig.Type
+		entry.ScmUsername = p.scmConfig.Username
+	}
+
+	var existingOrg *sonatypeiq.ApiOrganizationDTO
+	if parentId != "" {
+		var err error
+		existingOrg, err = p.server.OrganizationExists(o, parentId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if existingOrg != nil {
+		entry.Name = *existingOrg.Name
+		entry.ExistingId = *existingOrg.Id
+		if entry.ApplyScmConfiguration {
+			entry.Action = PLAN_ACTION_UPDATE
+			entry.Reason = "SCM configuration will be updated"
+		} else {
+			entry.Action = PLAN_ACTION_SKIP
+			entry.Reason = "already exists"
+		}
+	} else {
+		entry.Action = PLAN_ACTION_CREATE
+		entry.Name = p.uniqueOrganizationName(o.SafeName())
+		if entry.Name != o.SafeName() {
+			entry.Reason = fmt.Sprintf("name %s is already in use", o.SafeName())
+		}
+		p.plannedOrgNames[entry.Name] = true
+	}
+	p.entries = append(p.entries, entry)
+
+	for _, a := range o.Applications {
+		err := p.planApplication(a, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, a := range o.SkippedApplications {
+		p.entries = append(p.entries, PlanEntry{
+			Key:           scmKey(entry.Key, "", a.Name),
+			Entity:        PLAN_ENTITY_APPLICATION,
+			Action:        PLAN_ACTION_OMIT,
+			ParentKey:     entry.Key,
+			ParentId:      entry.ExistingId,
+			ScmName:       a.Name,
+			ScmProvider:   entry.ScmProvider,
+			RepositoryUrl: a.RepositoryUrl,
+			DefaultBranch: a.DefaultBranch,
+			Reason:        a.Reason,
+		})
+	}
+
+	for _, so := range o.SubOrganizations {
+		err := p.planOrganization(so, entry.Key, entry.ExistingId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *planner) planApplication(a scm.Application, org PlanEntry) error {
+	entry := PlanEntry{
+		Key:                   scmKey(org.Key, "", a.Name),
+		Entity:                PLAN_ENTITY_APPLICATION,
+		ParentKey:             org.Key,
+		ParentId:              org.ExistingId,
+		ScmName:               a.Name,
+		ScmProvider:           org.ScmProvider,
+		RepositoryUrl:         a.RepositoryUrl,
+		DefaultBranch:         a.DefaultBranch,
+		ApplyScmConfiguration: a.IsRepositoryUrlPermitted() && a.IsBranchNamePermitted(),
+	}
+
+	var existingApp *sonatypeiq.ApiApplicationDTO
+	var matchedBy string
+	if org.ExistingId != "" || p.server.applicationMatchStrategy == APPLICATION_MATCH_BY_REPOSITORY_URL || p.server.publicIdStrategy == PUBLIC_ID_STRATEGY_SCM_IDENTITY {
+		var err error
+		existingApp, matchedBy, err = p.server.findApplication(a, org.ExistingId)
+		var conflict *stableIdConflictError
+		if errors.As(err, &conflict) {
+			entry.Action = PLAN_ACTION_OMIT
+			entry.Name = a.SafeName()
+			entry.PublicId = conflict.PublicId
+			entry.Reason = conflict.Error()
+			p.entries = append(p.entries, entry)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if existingApp != nil {
+		entry.Name = *existingApp.Name
+		entry.PublicId = *existingApp.PublicId
+		entry.ExistingId = *existingApp.Id
+		reasons := make([]string, 0)
+		if existingApp.GetOrganizationId() != org.ExistingId {
+			entry.CurrentParentId = existingApp.GetOrganizationId()
+			reasons = append(reasons, fmt.Sprintf("matched by %s in Organization %s - will not be moved", matchedBy, p.server.organizationById(entry.CurrentParentId).GetName()))
+		}
+		if entry.ApplyScmConfiguration {
+			entry.Action = PLAN_ACTION_UPDATE
+			reasons = append(reasons, "source control configuration will be updated")
+		} else {
+			entry.Action = PLAN_ACTION_SKIP
+			reasons = append(reasons, fmt.Sprintf("unsupported Default Branch or Repository URL '%s'", a.RepositoryUrl))
+		}
+		entry.Reason = strings.Join(reasons, "; ")
+	} else if publicId := p.server.publicIdFor(a); p.server.publicIdStrategy == PUBLIC_ID_STRATEGY_SCM_IDENTITY && p.plannedPublicIds[publicId] {
+		// Only another Application for the same Repository can have the same stable ID
+		entry.Action = PLAN_ACTION_OMIT
+		entry.Name = a.SafeName()
+		entry.PublicId = publicId
+		entry.Reason = fmt.Sprintf("an Application with ID %s is already planned for the same Repository", publicId)
+	} else {
+		entry.Action = PLAN_ACTION_CREATE
+		entry.Name, entry.PublicId = p.uniqueApplicationNameAndId(a.SafeName(), publicId)
+		reasons := make([]string, 0)
+		if entry.Name != a.SafeName() || entry.PublicId != publicId {
+			reasons = append(reasons, fmt.Sprintf("name %s or ID %s is already in use", a.SafeName(), publicId))
+		}
+		if !entry.ApplyScmConfiguration {
+			reasons = append(reasons, fmt.Sprintf("source control skipped - unsupported Default Branch or Repository URL '%s'", a.RepositoryUrl))
+		}
+		entry.Reason = strings.Join(reasons, "; ")
+		p.plannedAppNames[entry.Name] = true
+		p.plannedPublicIds[entry.PublicId] = true
+	}
+	p.entries = append(p.entries, entry)
+
+	return nil
+}
+
+// uniqueOrganizationName mirrors the name bumping createOrganization performs on a collision.
+func (p *planner) uniqueOrganizationName(name string) string {
+	candidate := name
+	for attempt := 1; p.organizationNameInUse(candidate); attempt++ {
+		candidate = fmt.Sprintf("%s-%d", name, attempt)
+	}
+	return candidate
+}
+
+func (p *planner) organizationNameInUse(name string) bool {
+	if p.plannedOrgNames[name] {
+		return true
+	}
+	for _, existingOrg := range p.server.existingOrganizations {
+		if existingOrg.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueApplicationNameAndId mirrors the name and ID bumping createApplication performs on a collision -
+// where only the name is bumped under PUBLIC_ID_STRATEGY_SCM_IDENTITY.
+func (p *planner) uniqueApplicationNameAndId(name string, id string) (string, string) {
+	fixedId := p.server.publicIdStrategy == PUBLIC_ID_STRATEGY_SCM_IDENTITY
+	candidateName, candidateId := name, id
+	for attempt := 1; p.applicationNameInUse(candidateName) || (!fixedId && p.applicationIdInUse(candidateId)); attempt++ {
+		candidateName = fmt.Sprintf("%s-%d", name, attempt)
+		if !fixedId {
+			candidateId = fmt.Sprintf("%s-%d", id, attempt)
+		}
+	}
+	return candidateName, candidateId
+}
+
+func (p *planner) applicationNameInUse(name string) bool {
+	if p.plannedAppNames[name] {
+		return true
+	}
+	for _, existingApp := range p.server.existingApplications {
+		if existingApp.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *planner) applicationIdInUse(id string) bool {
+	if p.plannedPublicIds[id] {
+		return true
+	}
+	for _, existingApp := range p.server.existingApplications {
+		if existingApp.GetPublicId() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// scmKey identifies an entity by its path in the SCM, e.g. `azure:/my-account/my-project/my-repo`.
+func scmKey(parentKey string, provider string, name string) string {
+	if parentKey == "" {
+		return fmt.Sprintf("%s:/%s", provider, name)
+	}
+	return fmt.Sprintf("%s/%s", parentKey, name)
+}
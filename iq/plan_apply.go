@@ -0,0 +1,378 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	sonatypeiq "github.com/sonatype-nexus-community/nexus-iq-api-client-go"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/scm"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
+func LoadPlan(path string) (*Plan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan Plan
+	err = json.Unmarshal(b, &plan)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid plan file: %v", path, err)
+	}
+	if plan.FormatVersion != PLAN_FORMAT_VERSION {
+		return nil, fmt.Errorf("%s has plan format version %d but only version %d is supported", path, plan.FormatVersion, PLAN_FORMAT_VERSION)
+	}
+	for _, e := range plan.Entries {
+		if e.Entity == PLAN_ENTITY_APPLICATION && e.ApplyScmConfiguration && e.DefaultBranch == nil {
+			return nil, fmt.Errorf("%s is not a valid plan file: %s sets source control but has no defaultBranch", path, e.Key)
+		}
+	}
+
+	return &plan, nil
+}
+
+// ScmProvidersRequiringConfiguration returns the SCM providers whose credentials are needed to apply this Plan.
+func (p *Plan) ScmProvidersRequiringConfiguration() []string {
+	providers := make(map[string]bool)
+	for _, e := range p.Entries {
+		if e.Entity == PLAN_ENTITY_ORGANIZATION && e.Action != PLAN_ACTION_SKIP && e.ApplyScmConfiguration {
+			providers[e.ScmProvider] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(providers))
+	for provider := range providers {
+		sorted = append(sorted, provider)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// TargetOrganizationNames returns the names of the existing Organizations the Plan creates or updates top
+// level Organizations in.
+func (s *NxiqServer) TargetOrganizationNames(p *Plan) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, e := range p.Entries {
+		if e.ParentKey != "" || seen[e.ParentId] {
+			continue
+		}
+		seen[e.ParentId] = true
+		if org := s.organizationById(e.ParentId); org != nil {
+			names = append(names, org.GetName())
+		}
+	}
+	return names
+}
+
+func (s *NxiqServer) RefreshCache() error {
+	s.cacheLoaded = false
+	return s.InitCache()
+}
+
+/**
+ * Compares the current state of Sonatype Lifecycle with what the Plan assumed when it was made,
+ * returning a description of each difference. A Plan should only be applied if there are none.
+ */
+func (s *NxiqServer) ValidatePlan(plan *Plan) ([]string, error) {
+	err := s.SetApplicationMatchStrategy(plan.ApplicationMatchStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.RefreshCache()
+	if err != nil {
+		return nil, err
+	}
+
+	drift := make([]string, 0)
+	if plan.IqUrl != s.baseUrl {
+		drift = append(drift, fmt.Sprintf("plan was made against %s, not %s", plan.IqUrl, s.baseUrl))
+	}
+
+	for _, e := range plan.Entries {
+		if e.Action == PLAN_ACTION_OMIT {
+			continue
+		}
+		if s.journal.Completed(e.Key) != nil {
+			// Applied by a previous run that is being resumed
+			continue
+		}
+
+		if e.ParentId != "" && s.organizationById(e.ParentId) == nil {
+			drift = append(drift, fmt.Sprintf("%s: parent Organization %s no longer exists", e.Key, e.ParentId))
+		}
+
+		switch {
+		case e.Entity == PLAN_ENTITY_ORGANIZATION && e.Action == PLAN_ACTION_CREATE:
+			if s.organizationByName(e.Name) != nil {
+				drift = append(drift, fmt.Sprintf("%s: an Organization named %s now exists", e.Key, e.Name))
+			}
+
+		case e.Entity == PLAN_ENTITY_ORGANIZATION:
+			existingOrg := s.organizationById(e.ExistingId)
+			if existingOrg == nil {
+				drift = append(drift, fmt.Sprintf("%s: Organization %s (%s) no longer exists", e.Key, e.Name, e.ExistingId))
+			} else if existingOrg.GetName() != e.Name || (e.ParentId != "" && existingOrg.GetParentOrganizationId() != e.ParentId) {
+				drift = append(drift, fmt.Sprintf("%s: Organization %s has been renamed or moved", e.Key, e.ExistingId))
+			}
+
+		case e.Action == PLAN_ACTION_CREATE:
+			for _, existingApp := range s.existingApplications {
+				if existingApp.GetPublicId() == e.PublicId || existingApp.GetName() == e.Name {
+					drift = append(drift, fmt.Sprintf("%s: an Application with ID %s or name %s now exists", e.Key, e.PublicId, e.Name))
+					break
+				}
+			}
+			if existingApp := s.applicationsByRepositoryUrl[scm.NormalizeRepositoryUrl(e.RepositoryUrl)]; existingApp != nil {
+				drift = append(drift, fmt.Sprintf("%s: Application %s now exists for Repository URL %s", e.Key, existingApp.GetPublicId(), e.RepositoryUrl))
+			}
+
+		default:
+			expectedParentId := e.ParentId
+			if e.CurrentParentId != "" {
+				expectedParentId = e.CurrentParentId
+			}
+			existingApp := s.applicationById(e.ExistingId)
+			if existingApp == nil {
+				drift = append(drift, fmt.Sprintf("%s: Application %s (%s) no longer exists", e.Key, e.PublicId, e.ExistingId))
+			} else if existingApp.GetPublicId() != e.PublicId || (expectedParentId != "" && existingApp.GetOrganizationId() != expectedParentId) {
+				drift = append(drift, fmt.Sprintf("%s: Application %s has been changed or moved", e.Key, e.ExistingId))
+			}
+		}
+	}
+
+	return drift, nil
+}
+
+/**
+ * Performs exactly the operations recorded in the Plan - nothing is recomputed.
+ *
+ * `scmConfigs` holds the SCM configuration (credentials) to use for each SCM provider returned
+ * by ScmProvidersRequiringConfiguration().
+ */
+func (s *NxiqServer) ApplyPlan(plan *Plan, scmConfigs map[string]*scm.ScmConfiguration) error {
+	createdOrgIds := make(map[string]string)
+	failedOrgKeys := make(map[string]bool)
+	pool := util.NewWorkerPool(s.concurrency)
+
+	for _, e := range plan.Entries {
+		if e.Action == PLAN_ACTION_OMIT {
+			continue
+		}
+		if failedOrgKeys[e.ParentKey] {
+			s.report.RecordSkipped(e.Entity, e.Key, e.Name, e.ParentKey)
+			failedOrgKeys[e.Key] = true
+			continue
+		}
+
+		parentId := e.ParentId
+		if parentId == "" {
+			parentId = createdOrgIds[e.ParentKey]
+		}
+		if parentId == "" && e.Action != PLAN_ACTION_SKIP {
+			pool.Wait()
+			return fmt.Errorf("%s: parent Organization %s was not created", e.Key, e.ParentKey)
+		}
+
+		if completed := s.journal.Completed(e.Key); completed != nil {
+			log.Debug(fmt.Sprintf("%s already completed in a previous run - %s", e.Key, completed.IqId))
+			createdOrgIds[e.Key] = completed.IqId
+			continue
+		}
+
+		if e.Entity == PLAN_ENTITY_ORGANIZATION {
+			iqId, err := s.applyPlannedOrganization(e, parentId, scmConfigs)
+			if err != nil && s.continueOnError {
+				log.Error(fmt.Sprintf("Failed to apply Organization %s - skipping everything within it: %v", e.Key, err))
+				s.report.RecordFailure(e.Entity, e.Key, e.Name, err)
+				failedOrgKeys[e.Key] = true
+				continue
+			}
+			if err == nil {
+				createdOrgIds[e.Key] = iqId
+				err = s.recordPlanEntry(e, iqId)
+			}
+			if err != nil {
+				pool.Wait()
+				return err
+			}
+			continue
+		}
+
+		// Applications are applied in parallel, once the Organization they belong to exists
+		err := pool.Submit(func() error {
+			iqId, err := s.applyPlannedApplication(e, parentId)
+			if err != nil {
+				if !s.continueOnError {
+					return err
+				}
+				log.Error(fmt.Sprintf("Failed to apply Application %s: %v", e.Key, err))
+				s.report.RecordFailure(e.Entity, e.Key, e.Name, err)
+				return nil
+			}
+			return s.recordPlanEntry(e, iqId)
+		})
+		if err != nil {
+			pool.Wait()
+			return err
+		}
+	}
+
+	return pool.Wait()
+}
+
+func (s *NxiqServer) recordPlanEntry(e PlanEntry, iqId string) error {
+	if e.Action == PLAN_ACTION_SKIP {
+		return nil
+	}
+	s.report.RecordSuccess(e.Entity, e.Key, e.Name, e.Action, iqId)
+	return s.journal.Record(JournalEntry{
+		Key:           e.Key,
+		Entity:        e.Entity,
+		Action:        e.Action,
+		IqId:          iqId,
+		Name:          e.Name,
+		PublicId:      e.PublicId,
+		RepositoryUrl: e.RepositoryUrl,
+	})
+}
+
+func (s *NxiqServer) applyPlannedOrganization(e PlanEntry, parentId string, scmConfigs map[string]*scm.ScmConfiguration) (string, error) {
+	var scmConfig *scm.ScmConfiguration
+	if e.ApplyScmConfiguration && e.Action != PLAN_ACTION_SKIP {
+		if scmConfigs[e.ScmProvider] == nil {
+			return "", fmt.Errorf("%s: no %s SCM configuration supplied", e.Key, e.ScmProvider)
+		}
+		scmConfig = &scm.ScmConfiguration{
+			Type:     e.ScmProvider,
+			Username: e.ScmUsername,
+			Password: scmConfigs[e.ScmProvider].Password,
+		}
+	}
+
+	switch e.Action {
+	case PLAN_ACTION_CREATE:
+		createdOrg, r, err := s.apiClient.OrganizationsAPI.AddOrganization(*s.apiContext).ApiOrganizationDTO(sonatypeiq.ApiOrganizationDTO{
+			Name:                 &e.Name,
+			ParentOrganizationId: &parentId,
+		}).Execute()
+		if err != nil {
+			log.Debug(fmt.Sprintf("Full HTTP response: %v", r))
+			return "", fmt.Errorf("%s: %w", e.Key, newIqApiError(fmt.Sprintf("Create Organization %s", e.Name), r, err))
+		}
+		log.Debug(fmt.Sprintf("Created Organization %s - %s", e.Name, *createdOrg.Id))
+
+		if scmConfig != nil {
+			err = s.SetOrganizationScmConfiguration(createdOrg, scmConfig)
+			if err != nil {
+				return "", fmt.Errorf("%s: failed to set SCM configuration: %w", e.Key, err)
+			}
+		}
+		return *createdOrg.Id, nil
+
+	case PLAN_ACTION_UPDATE:
+		if scmConfig != nil {
+			err := s.UpdateOrganizationScmConfiguration(&sonatypeiq.ApiOrganizationDTO{Id: &e.ExistingId}, scmConfig)
+			if err != nil {
+				return "", fmt.Errorf("%s: failed to update SCM configuration: %w", e.Key, err)
+			}
+			log.Debug(fmt.Sprintf("Updated %s SCM Configuration for Organization %s - %s", scmConfig.Type, e.Name, e.ExistingId))
+		}
+	}
+
+	return e.ExistingId, nil
+}
+
+func (s *NxiqServer) applyPlannedApplication(e PlanEntry, parentId string) (string, error) {
+	app := scm.Application{
+		Name:          e.ScmName,
+		RepositoryUrl: e.RepositoryUrl,
+		DefaultBranch: e.DefaultBranch,
+	}
+
+	var iqApp *sonatypeiq.ApiApplicationDTO
+	var err error
+	switch e.Action {
+	case PLAN_ACTION_CREATE:
+		var r *http.Response
+		iqApp, r, err = s.apiClient.ApplicationsAPI.AddApplication(*s.apiContext).ApiApplicationDTO(sonatypeiq.ApiApplicationDTO{
+			PublicId:       &e.PublicId,
+			Name:           &e.Name,
+			OrganizationId: &parentId,
+		}).Execute()
+		if err != nil {
+			log.Debug(fmt.Sprintf("Full HTTP response: %v", r))
+			return "", fmt.Errorf("%s: %w", e.Key, newIqApiError(fmt.Sprintf("Create Application %s", e.PublicId), r, err))
+		}
+		log.Debug(fmt.Sprintf("Created Application %s - %s", e.Name, *iqApp.Id))
+
+		if e.ApplyScmConfiguration {
+			_, err = s.addApplicationSourceControl(*iqApp.Id, app)
+		}
+
+	case PLAN_ACTION_UPDATE:
+		iqApp = &sonatypeiq.ApiApplicationDTO{Id: &e.ExistingId}
+		_, err = s.updateApplicationSourceControl(e.ExistingId, app)
+
+	default:
+		return e.ExistingId, nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("%s: failed to configure source control: %w", e.Key, err)
+	}
+	if e.ApplyScmConfiguration {
+		s.scheduleSourceStageScan(iqApp, e.DefaultBranch)
+	}
+	return *iqApp.Id, nil
+}
+
+func (s *NxiqServer) organizationById(id string) *sonatypeiq.ApiOrganizationDTO {
+	for _, o := range s.existingOrganizations {
+		if o.GetId() == id {
+			return o
+		}
+	}
+	return nil
+}
+
+func (s *NxiqServer) organizationByName(name string) *sonatypeiq.ApiOrganizationDTO {
+	for _, o := range s.existingOrganizations {
+		if o.GetName() == name {
+			return o
+		}
+	}
+	return nil
+}
+
+func (s *NxiqServer) applicationById(id string) *sonatypeiq.ApiApplicationDTO {
+	for _, a := range s.existingApplications {
+		if a.GetId() == id {
+			return a
+		}
+	}
+	return nil
+}
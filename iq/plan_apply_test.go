@@ -0,0 +1,141 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newIqTestServer serves the supplied Organizations and Applications, and the source control
+// configuration of Applications keyed by their internal ID.
+func newIqTestServer(organizationsJson string, applicationsJson string, sourceControlJson map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v2/organizations":
+			fmt.Fprint(w, organizationsJson)
+		case r.URL.Path == "/api/v2/applications":
+			fmt.Fprint(w, applicationsJson)
+		case strings.HasPrefix(r.URL.Path, "/api/v2/sourceControl/application/"):
+			scmJson, ok := sourceControlJson[strings.TrimPrefix(r.URL.Path, "/api/v2/sourceControl/application/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, scmJson)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestValidatePlan(t *testing.T) {
+	iqServer := newIqTestServer(
+		`{"organizations":[{"id":"ROOT_ORGANIZATION_ID","name":"Root Organization"},{"id":"org-acme","name":"acme","parentOrganizationId":"ROOT_ORGANIZATION_ID"},{"id":"org-new","name":"Backend","parentOrganizationId":"org-acme"}]}`,
+		`{"applications":[{"id":"app-1","publicId":"widget","name":"widget","organizationId":"org-moved"}]}`,
+		nil,
+	)
+	defer iqServer.Close()
+
+	plan := &Plan{
+		FormatVersion: PLAN_FORMAT_VERSION,
+		IqUrl:         iqServer.URL,
+		Entries: []PlanEntry{
+			{Key: "github:/acme", Entity: PLAN_ENTITY_ORGANIZATION, Action: PLAN_ACTION_SKIP, ParentId: "ROOT_ORGANIZATION_ID", Name: "acme", ExistingId: "org-acme"},
+			{Key: "github:/acme/widget", Entity: PLAN_ENTITY_APPLICATION, Action: PLAN_ACTION_UPDATE, ParentKey: "github:/acme", ParentId: "org-acme", Name: "widget", PublicId: "widget", ExistingId: "app-1"},
+			{Key: "github:/acme/fork", Entity: PLAN_ENTITY_APPLICATION, Action: PLAN_ACTION_OMIT, ParentKey: "github:/acme", ParentId: "org-acme", Reason: "repository is a fork"},
+			{Key: "github:/acme/Backend", Entity: PLAN_ENTITY_ORGANIZATION, Action: PLAN_ACTION_CREATE, ParentKey: "github:/acme", ParentId: "org-acme", Name: "Backend"},
+			{Key: "github:/acme/Backend/api", Entity: PLAN_ENTITY_APPLICATION, Action: PLAN_ACTION_CREATE, ParentKey: "github:/acme/Backend", Name: "api", PublicId: "api"},
+		},
+	}
+
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	drift, err := s.ValidatePlan(plan)
+	assert.NoError(t, err)
+	assert.Len(t, drift, 2)
+	assert.Contains(t, drift[0], "github:/acme/widget")
+	assert.Contains(t, drift[1], "an Organization named Backend now exists")
+
+	// Round trip through a plan file
+	path := filepath.Join(t.TempDir(), "plan.json")
+	assert.NoError(t, plan.Save(path))
+	loaded, err := LoadPlan(path)
+	assert.NoError(t, err)
+	assert.Equal(t, plan.Entries, loaded.Entries)
+}
+
+func TestLoadPlanWithoutDefaultBranch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	writePlan := func(applyScmConfiguration bool) {
+		json := fmt.Sprintf(`{"formatVersion":%d,"entries":[
+			{"key":"github:/acme","entity":"organization","action":"skip","name":"acme","existingId":"org-acme"},
+			{"key":"github:/acme/widget","entity":"application","action":"create","parentKey":"github:/acme","scmName":"widget","name":"widget","publicId":"widget","repositoryUrl":"https://github.com/acme/widget","applyScmConfiguration":%t}
+		]}`, PLAN_FORMAT_VERSION, applyScmConfiguration)
+		assert.NoError(t, os.WriteFile(path, []byte(json), 0600))
+	}
+
+	writePlan(false)
+	plan, err := LoadPlan(path)
+	assert.NoError(t, err)
+	assert.Nil(t, plan.Entries[1].DefaultBranch)
+	assert.Equal(t, "APP: widget (create as widget with ID widget)", plan.Entries[1].describe())
+
+	writePlan(true)
+	_, err = LoadPlan(path)
+	assert.ErrorContains(t, err, "github:/acme/widget sets source control but has no defaultBranch")
+
+	// Plans built in code are described without panicking
+	plan.Entries[1].ApplyScmConfiguration = true
+	assert.Equal(t, "APP: widget (create as widget with ID widget) [source control: https://github.com/acme/widget]", plan.Entries[1].describe())
+}
+
+func TestValidatePlanMatchingByRepositoryUrl(t *testing.T) {
+	iqServer := newIqTestServer(
+		`{"organizations":[{"id":"ROOT_ORGANIZATION_ID","name":"Root Organization"},{"id":"org-acme","name":"acme","parentOrganizationId":"ROOT_ORGANIZATION_ID"},{"id":"org-legacy","name":"Legacy","parentOrganizationId":"ROOT_ORGANIZATION_ID"}]}`,
+		`{"applications":[{"id":"app-1","publicId":"widget","name":"widget","organizationId":"org-legacy"},{"id":"app-2","publicId":"gadget-app","name":"Gadget","organizationId":"org-legacy"}]}`,
+		map[string]string{
+			"app-1": `{"repositoryUrl":"https://github.com/acme/widget.git"}`,
+			"app-2": `{"repositoryUrl":"https://github.com/acme/gadget"}`,
+		},
+	)
+	defer iqServer.Close()
+
+	plan := &Plan{
+		FormatVersion:            PLAN_FORMAT_VERSION,
+		IqUrl:                    iqServer.URL,
+		ApplicationMatchStrategy: APPLICATION_MATCH_BY_REPOSITORY_URL,
+		Entries: []PlanEntry{
+			{Key: "github:/acme", Entity: PLAN_ENTITY_ORGANIZATION, Action: PLAN_ACTION_SKIP, ParentId: "ROOT_ORGANIZATION_ID", Name: "acme", ExistingId: "org-acme"},
+			{Key: "github:/acme/widget", Entity: PLAN_ENTITY_APPLICATION, Action: PLAN_ACTION_UPDATE, ParentKey: "github:/acme", ParentId: "org-acme", CurrentParentId: "org-legacy", Name: "widget", PublicId: "widget", ExistingId: "app-1"},
+			{Key: "github:/acme/gadget", Entity: PLAN_ENTITY_APPLICATION, Action: PLAN_ACTION_CREATE, ParentKey: "github:/acme", ParentId: "org-acme", Name: "gadget", PublicId: "gadget", RepositoryUrl: "git@github.com:acme/gadget.git"},
+		},
+	}
+
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	drift, err := s.ValidatePlan(plan)
+	assert.NoError(t, err)
+	assert.Len(t, drift, 1)
+	assert.Contains(t, drift[0], "Application gadget-app now exists for Repository URL")
+}
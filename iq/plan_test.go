@@ -0,0 +1,297 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"fmt"
+	"testing"
+
+	sonatypeiq "github.com/sonatype-nexus-community/nexus-iq-api-client-go"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/scm"
+	"github.com/stretchr/testify/assert"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// newCachedTestServer returns a server whose cache is pre-loaded, so nothing calls out to IQ.
+func newCachedTestServer(orgs []*sonatypeiq.ApiOrganizationDTO, apps []*sonatypeiq.ApiApplicationDTO) *NxiqServer {
+	s := NewNxiqServer("http://localhost:8070", "admin", "admin123")
+	s.cacheLoaded = true
+	s.existingOrganizations = orgs
+	s.existingApplications = apps
+	return s
+}
+
+func TestPlanOrgContents(t *testing.T) {
+	root := &sonatypeiq.ApiOrganizationDTO{Id: strPtr("ROOT_ORGANIZATION_ID"), Name: strPtr("Root Organization")}
+	s := newCachedTestServer(
+		[]*sonatypeiq.ApiOrganizationDTO{
+			root,
+			{Id: strPtr("org-acme"), Name: strPtr("acme"), ParentOrganizationId: strPtr("ROOT_ORGANIZATION_ID")},
+			{Id: strPtr("org-other"), Name: strPtr("Backend"), ParentOrganizationId: strPtr("org-elsewhere")},
+		},
+		[]*sonatypeiq.ApiApplicationDTO{
+			{Id: strPtr("app-1"), PublicId: strPtr("widget"), Name: strPtr("widget"), OrganizationId: strPtr("org-acme")},
+			{Id: strPtr("app-2"), PublicId: strPtr("api"), Name: strPtr("api"), OrganizationId: strPtr("org-elsewhere")},
+		},
+	)
+
+	orgContents := scm.OrgContents{
+		Organizations: []scm.Organization{
+			{
+				Name:                  "acme",
+				ScmProvider:           scm.SCM_TYPE_GITHUB,
+				ApplyScmConfiguration: true,
+				Applications: []scm.Application{
+					{Name: "widget", RepositoryUrl: "https://github.com/acme/widget", DefaultBranch: strPtr("main")},
+					{Name: "bad", RepositoryUrl: "https://github.com/acme/bad", DefaultBranch: strPtr("bad(branch")},
+				},
+				SkippedApplications: []scm.SkippedApplication{
+					{Application: scm.Application{Name: "empty", RepositoryUrl: "https://github.com/acme/empty"}, Reason: "repository is empty (no Default Branch)"},
+				},
+				SubOrganizations: []scm.Organization{
+					{
+						Name:        "Backend",
+						ScmProvider: scm.SCM_TYPE_GITHUB,
+						Applications: []scm.Application{
+							{Name: "api", RepositoryUrl: "https://github.com/acme/api", DefaultBranch: strPtr("main")},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	plan, err := s.PlanOrgContents(orgContents, root, &scm.ScmConfiguration{Type: scm.SCM_TYPE_GITHUB, Password: "secret"})
+	assert.NoError(t, err)
+	assert.Len(t, plan.Entries, 6)
+
+	acme := plan.Entries[0]
+	assert.Equal(t, "github:/acme", acme.Key)
+	assert.Equal(t, PLAN_ACTION_UPDATE, acme.Action)
+	assert.Equal(t, "org-acme", acme.ExistingId)
+	assert.Equal(t, "ROOT_ORGANIZATION_ID", acme.ParentId)
+
+	widget := plan.Entries[1]
+	assert.Equal(t, PLAN_ACTION_UPDATE, widget.Action)
+	assert.Equal(t, "app-1", widget.ExistingId)
+
+	bad := plan.Entries[2]
+	assert.Equal(t, PLAN_ACTION_CREATE, bad.Action)
+	assert.False(t, bad.ApplyScmConfiguration)
+
+	empty := plan.Entries[3]
+	assert.Equal(t, "github:/acme/empty", empty.Key)
+	assert.Equal(t, PLAN_ACTION_OMIT, empty.Action)
+	assert.Equal(t, "repository is empty (no Default Branch)", empty.Reason)
+	assert.Contains(t, empty.describe(), "(not onboarded)")
+
+	backend := plan.Entries[4]
+	assert.Equal(t, "github:/acme/Backend", backend.Key)
+	assert.Equal(t, PLAN_ACTION_CREATE, backend.Action)
+	assert.Equal(t, "Backend-1", backend.Name)
+	assert.Equal(t, "github:/acme", backend.ParentKey)
+	assert.Equal(t, "org-acme", backend.ParentId)
+
+	api := plan.Entries[5]
+	assert.Equal(t, PLAN_ACTION_CREATE, api.Action)
+	assert.Equal(t, "api-1", api.PublicId)
+	assert.Equal(t, "github:/acme/Backend", api.ParentKey)
+	assert.Equal(t, "", api.ParentId)
+
+	assert.Equal(t, 3, plan.Count(PLAN_ACTION_CREATE))
+	assert.Equal(t, 2, plan.Count(PLAN_ACTION_UPDATE))
+	assert.Equal(t, 1, plan.Count(PLAN_ACTION_OMIT))
+	assert.True(t, plan.HasChanges())
+	assert.False(t, (&Plan{Entries: []PlanEntry{{Action: PLAN_ACTION_SKIP}}}).HasChanges())
+}
+
+func TestPlanOrgContentsMatchingByRepositoryUrl(t *testing.T) {
+	iqServer := newIqTestServer(
+		`{"organizations":[{"id":"ROOT_ORGANIZATION_ID","name":"Root Organization"},{"id":"org-acme","name":"acme","parentOrganizationId":"ROOT_ORGANIZATION_ID"},{"id":"org-legacy","name":"Legacy","parentOrganizationId":"ROOT_ORGANIZATION_ID"}]}`,
+		`{"applications":[{"id":"app-1","publicId":"old-widget","name":"Old Widget","organizationId":"org-legacy"},{"id":"app-2","publicId":"api","name":"api","organizationId":"org-acme"},{"id":"app-3","publicId":"docs","name":"docs","organizationId":"org-acme"}]}`,
+		map[string]string{
+			"app-1": `{"repositoryUrl":"https://github.com/Acme/Widget.git"}`,
+			"app-2": `{"repositoryUrl":"https://github.com/other/api"}`,
+		},
+	)
+	defer iqServer.Close()
+
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	assert.NoError(t, s.SetApplicationMatchStrategy(APPLICATION_MATCH_BY_REPOSITORY_URL))
+	root := s.ValidateOrganizationByName("Root Organization")
+
+	orgContents := scm.OrgContents{
+		Organizations: []scm.Organization{
+			{
+				Name:        "acme",
+				ScmProvider: scm.SCM_TYPE_GITHUB,
+				Applications: []scm.Application{
+					{Name: "widget", RepositoryUrl: "https://github.com/acme/widget", DefaultBranch: strPtr("main")},
+					{Name: "api", RepositoryUrl: "https://github.com/acme/api", DefaultBranch: strPtr("main")},
+					{Name: "docs", RepositoryUrl: "https://github.com/acme/docs", DefaultBranch: strPtr("main")},
+				},
+			},
+		},
+	}
+
+	plan, err := s.PlanOrgContents(orgContents, root, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, APPLICATION_MATCH_BY_REPOSITORY_URL, plan.ApplicationMatchStrategy)
+	assert.Len(t, plan.Entries, 4)
+
+	// Renamed and moved - recognized by Repository URL and left where it is
+	widget := plan.Entries[1]
+	assert.Equal(t, PLAN_ACTION_UPDATE, widget.Action)
+	assert.Equal(t, "app-1", widget.ExistingId)
+	assert.Equal(t, "org-legacy", widget.CurrentParentId)
+	assert.Contains(t, widget.Reason, "matched by Repository URL in Organization Legacy")
+
+	// Same name but a different repository
+	api := plan.Entries[2]
+	assert.Equal(t, PLAN_ACTION_CREATE, api.Action)
+	assert.Equal(t, "api-1", api.PublicId)
+
+	// Existing Application without source control still matches by name
+	docs := plan.Entries[3]
+	assert.Equal(t, PLAN_ACTION_UPDATE, docs.Action)
+	assert.Equal(t, "app-3", docs.ExistingId)
+	assert.Equal(t, "", docs.CurrentParentId)
+}
+
+func TestPlanOrgContentsWithStableIds(t *testing.T) {
+	widget := scm.Application{Name: "widget", ScmId: "5FEBEF5A-833D-4E14-B9C0-14CB638F91E6", RepositoryUrl: "https://dev.azure.com/acme/web/_git/widget", DefaultBranch: strPtr("main")}
+	api := scm.Application{Name: "api", RepositoryUrl: "https://dev.azure.com/acme/web/_git/api", DefaultBranch: strPtr("main")}
+	// Has the same stable ID as an unrelated Application - e.g. from another server numbering Repositories alike
+	clash := scm.Application{Name: "clash", ScmId: "github-github.com-42", RepositoryUrl: "https://dev.azure.com/acme/web/_git/clash", DefaultBranch: strPtr("main")}
+	iqServer := newIqTestServer(
+		`{"organizations":[{"id":"ROOT_ORGANIZATION_ID","name":"Root Organization"},{"id":"org-legacy","name":"Legacy","parentOrganizationId":"ROOT_ORGANIZATION_ID"}]}`,
+		fmt.Sprintf(`{"applications":[{"id":"app-1","publicId":"%s","name":"Old Widget","organizationId":"org-legacy"},{"id":"app-2","publicId":"other","name":"api","organizationId":"org-legacy"},{"id":"app-3","publicId":"%s","name":"Unrelated","organizationId":"org-legacy"}]}`, widget.StableId(), clash.StableId()),
+		map[string]string{
+			"app-1": `{"repositoryUrl":"https://dev.azure.com/acme/web/_git/widget"}`,
+			"app-3": `{"repositoryUrl":"https://github.com/acme/unrelated"}`,
+		},
+	)
+	defer iqServer.Close()
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	assert.NoError(t, s.SetPublicIdStrategy(PUBLIC_ID_STRATEGY_SCM_IDENTITY))
+	root := s.ValidateOrganizationByName("Root Organization")
+
+	orgContents := scm.OrgContents{
+		Organizations: []scm.Organization{
+			{
+				Name:         "web",
+				ScmProvider:  scm.SCM_TYPE_AZURE,
+				Applications: []scm.Application{widget, api, api, clash},
+			},
+		},
+	}
+
+	plan, err := s.PlanOrgContents(*orgContents.UseStableIds(), root, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, PUBLIC_ID_STRATEGY_SCM_IDENTITY, plan.PublicIdStrategy)
+	assert.Len(t, plan.Entries, 5)
+
+	// Recognized by its stable ID, wherever it lives and whatever it is called
+	assert.Equal(t, "repo-5febef5a-833d-4e14-b9c0-14cb638f91e6", widget.StableId())
+	assert.Equal(t, PLAN_ACTION_UPDATE, plan.Entries[1].Action)
+	assert.Equal(t, "app-1", plan.Entries[1].ExistingId)
+	assert.Contains(t, plan.Entries[1].Reason, "matched by ID repo-5febef5a-833d-4e14-b9c0-14cb638f91e6 in Organization Legacy")
+
+	// Only the name is suffixed on a collision - never the ID
+	assert.Equal(t, PLAN_ACTION_CREATE, plan.Entries[2].Action)
+	assert.Equal(t, "api-1", plan.Entries[2].Name)
+	assert.Equal(t, api.StableId(), plan.Entries[2].PublicId)
+	assert.Regexp(t, "^repo-[0-9a-f]{20}$", plan.Entries[2].PublicId)
+
+	// The same Repository twice
+	assert.Equal(t, PLAN_ACTION_OMIT, plan.Entries[3].Action)
+	assert.Contains(t, plan.Entries[3].Reason, "already planned for the same Repository")
+
+	// An Application with the ID but a different Repository is never taken over
+	assert.Equal(t, PLAN_ACTION_OMIT, plan.Entries[4].Action)
+	assert.Equal(t, "", plan.Entries[4].ExistingId)
+	assert.Contains(t, plan.Entries[4].Reason, "Application Unrelated already has ID repo-github-github.com-42 but is for Repository github.com/acme/unrelated")
+}
+
+func TestReserveApplicationNameAndIdWithStableIds(t *testing.T) {
+	s := newCachedTestServer(nil, []*sonatypeiq.ApiApplicationDTO{
+		{Id: strPtr("app-1"), PublicId: strPtr("repo-abc"), Name: strPtr("widget"), OrganizationId: strPtr("org-acme")},
+	})
+
+	name, id, err := s.reserveApplicationNameAndId("widget", "widget", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "widget-1", name)
+	assert.Equal(t, "widget-1", id)
+
+	assert.NoError(t, s.SetPublicIdStrategy(PUBLIC_ID_STRATEGY_SCM_IDENTITY))
+	name, id, err = s.reserveApplicationNameAndId("widget", "repo-def", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "widget-2", name)
+	assert.Equal(t, "repo-def", id)
+
+	_, _, err = s.reserveApplicationNameAndId("gadget", "repo-abc", 0)
+	assert.ErrorContains(t, err, "Application ID repo-abc is already in use")
+	assert.ErrorContains(t, s.SetPublicIdStrategy("guid"), "unknown public ID strategy 'guid'")
+}
+
+func TestPlanSources(t *testing.T) {
+	root := &sonatypeiq.ApiOrganizationDTO{Id: strPtr("ROOT_ORGANIZATION_ID"), Name: strPtr("Root Organization")}
+	imported := &sonatypeiq.ApiOrganizationDTO{Id: strPtr("org-imported"), Name: strPtr("Imported"), ParentOrganizationId: strPtr("ROOT_ORGANIZATION_ID")}
+	s := newCachedTestServer([]*sonatypeiq.ApiOrganizationDTO{root, imported}, []*sonatypeiq.ApiApplicationDTO{})
+
+	azure := ImportSource{
+		Name:             "Azure DevOps",
+		RootOrganization: root,
+		ScmConfig:        &scm.ScmConfiguration{Type: scm.SCM_TYPE_AZURE, Password: "pat"},
+		OrgContents: scm.OrgContents{Organizations: []scm.Organization{
+			{Name: "platform", ScmProvider: scm.SCM_TYPE_AZURE, ApplyScmConfiguration: true, Applications: []scm.Application{
+				{Name: "api", RepositoryUrl: "https://dev.azure.com/acme/platform/_git/api", DefaultBranch: strPtr("main")},
+			}},
+		}},
+	}
+	gitlab := ImportSource{
+		Name:             "GitLab",
+		RootOrganization: imported,
+		OrgContents: scm.OrgContents{Organizations: []scm.Organization{
+			{Name: "platform", ScmProvider: scm.SCM_TYPE_GITLAB, ApplyScmConfiguration: true, Applications: []scm.Application{
+				{Name: "api", RepositoryUrl: "https://gitlab.com/platform/api", DefaultBranch: strPtr("main")},
+			}},
+		}},
+	}
+
+	plan, err := s.PlanSources([]ImportSource{azure, gitlab})
+	assert.NoError(t, err)
+	assert.Len(t, plan.Entries, 4)
+
+	assert.Equal(t, "azure:/platform", plan.Entries[0].Key)
+	assert.Equal(t, "ROOT_ORGANIZATION_ID", plan.Entries[0].ParentId)
+	assert.True(t, plan.Entries[0].ApplyScmConfiguration)
+
+	// Names planned for the first source are not reused by the second
+	assert.Equal(t, "gitlab:/platform", plan.Entries[2].Key)
+	assert.Equal(t, "org-imported", plan.Entries[2].ParentId)
+	assert.Equal(t, "platform-1", plan.Entries[2].Name)
+	assert.False(t, plan.Entries[2].ApplyScmConfiguration)
+	assert.Equal(t, "api-1", plan.Entries[3].PublicId)
+
+	// The same SCM Organization cannot be loaded twice
+	_, err = s.PlanSources([]ImportSource{azure, azure})
+	assert.ErrorContains(t, err, "azure:/platform is loaded by both Azure DevOps and Azure DevOps")
+}
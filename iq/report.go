@@ -0,0 +1,169 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	sonatypeiq "github.com/sonatype-nexus-community/nexus-iq-api-client-go"
+)
+
+// IqApiError describes a failed call to Sonatype Lifecycle, keeping the HTTP status and response body.
+type IqApiError struct {
+	Operation    string
+	StatusCode   int
+	ResponseBody string
+	Err          error
+}
+
+func (e *IqApiError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s failed: %v", e.Operation, e.Err)
+	}
+	return fmt.Sprintf("%s failed with HTTP %d: %v", e.Operation, e.StatusCode, e.Err)
+}
+
+func (e *IqApiError) Unwrap() error {
+	return e.Err
+}
+
+func newIqApiError(operation string, r *http.Response, err error) error {
+	apiErr := &IqApiError{
+		Operation: operation,
+		Err:       err,
+	}
+	if r != nil {
+		apiErr.StatusCode = r.StatusCode
+	}
+	var openApiErr *sonatypeiq.GenericOpenAPIError
+	if errors.As(err, &openApiErr) {
+		apiErr.ResponseBody = strings.TrimSpace(string(openApiErr.Body()))
+	}
+	return apiErr
+}
+
+type ReportEntry struct {
+	Entity       string `json:"entity"`
+	Key          string `json:"key"`
+	Name         string `json:"name"`
+	Action       string `json:"action,omitempty"`
+	IqId         string `json:"iqId,omitempty"`
+	StatusCode   int    `json:"statusCode,omitempty"`
+	ResponseBody string `json:"responseBody,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+/**
+ * A summary of a run: every Organization and Application that was created or updated, every one that
+ * failed (with the HTTP status and response body from Sonatype Lifecycle, where there was one) and every
+ * one that was not attempted because the Organization it belongs in failed.
+ *
+ * Safe to use from multiple goroutines.
+ */
+type Report struct {
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	Succeeded  []ReportEntry `json:"succeeded"`
+	Failed     []ReportEntry `json:"failed"`
+	Skipped    []ReportEntry `json:"skipped"`
+	mu         sync.Mutex
+}
+
+func NewReport() *Report {
+	return &Report{
+		StartedAt: time.Now().UTC(),
+		Succeeded: make([]ReportEntry, 0),
+		Failed:    make([]ReportEntry, 0),
+		Skipped:   make([]ReportEntry, 0),
+	}
+}
+
+func (r *Report) RecordSuccess(entity string, key string, name string, action string, iqId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Succeeded = append(r.Succeeded, ReportEntry{Entity: entity, Key: key, Name: name, Action: action, IqId: iqId})
+}
+
+func (r *Report) RecordFailure(entity string, key string, name string, err error) {
+	entry := ReportEntry{Entity: entity, Key: key, Name: name, Error: err.Error()}
+	var apiErr *IqApiError
+	if errors.As(err, &apiErr) {
+		entry.StatusCode = apiErr.StatusCode
+		entry.ResponseBody = apiErr.ResponseBody
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failed = append(r.Failed, entry)
+}
+
+// RecordSkipped records an entity that was not attempted because the Organization it belongs in failed.
+func (r *Report) RecordSkipped(entity string, key string, name string, parentKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped = append(r.Skipped, ReportEntry{Entity: entity, Key: key, Name: name, Error: fmt.Sprintf("parent Organization %s failed", parentKey)})
+}
+
+func (r *Report) HasFailures() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Failed) > 0 || len(r.Skipped) > 0
+}
+
+// IsEmpty reports whether nothing was created, updated or attempted - i.e. there was nothing to do.
+func (r *Report) IsEmpty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Succeeded) == 0 && len(r.Failed) == 0 && len(r.Skipped) == 0
+}
+
+func (r *Report) Print() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	println("")
+	println(fmt.Sprintf("Summary: %d succeeded, %d failed, %d skipped because their Organization failed.", len(r.Succeeded), len(r.Failed), len(r.Skipped)))
+	for _, f := range r.Failed {
+		line := fmt.Sprintf("  ❌ %s %s: %s", f.Entity, f.Key, f.Error)
+		if f.ResponseBody != "" {
+			line = fmt.Sprintf("%s - %s", line, f.ResponseBody)
+		}
+		println(line)
+	}
+	for _, s := range r.Skipped {
+		println(fmt.Sprintf("  ⏭  %s %s: %s", s.Entity, s.Key, s.Error))
+	}
+}
+
+func (r *Report) Save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.FinishedAt = time.Now().UTC()
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
@@ -22,33 +22,77 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
 	sonatypeiq "github.com/sonatype-nexus-community/nexus-iq-api-client-go"
 	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/scm"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
 )
 
+const (
+	APPLICATION_MATCH_BY_NAME           = "name"
+	APPLICATION_MATCH_BY_REPOSITORY_URL = "repository-url"
+	PUBLIC_ID_STRATEGY_NAME             = "name"
+	PUBLIC_ID_STRATEGY_SCM_IDENTITY     = "scm-identity"
+)
+
+/**
+ * An existing Application that was matched by its Repository URL, but which lives in a different
+ * Organization to the one the SCM structure places it in. It is updated where it is - never moved
+ * or duplicated.
+ */
+type MovedApplication struct {
+	ApplicationId          string
+	PublicId               string
+	Name                   string
+	RepositoryUrl          string
+	OrganizationId         string
+	OrganizationName       string
+	ExpectedOrganizationId string
+}
+
 type NxiqServer struct {
-	baseUrl               string
-	username              string
-	password              string
-	apiClient             *sonatypeiq.APIClient
-	apiContext            *context.Context
-	configuration         *sonatypeiq.Configuration
-	cacheLoaded           bool
-	existingApplications  []*sonatypeiq.ApiApplicationDTO
-	existingOrganizations []*sonatypeiq.ApiOrganizationDTO
+	baseUrl                     string
+	username                    string
+	password                    string
+	apiClient                   *sonatypeiq.APIClient
+	apiContext                  *context.Context
+	configuration               *sonatypeiq.Configuration
+	cacheLoaded                 bool
+	existingApplications        []*sonatypeiq.ApiApplicationDTO
+	existingOrganizations       []*sonatypeiq.ApiOrganizationDTO
+	applicationMatchStrategy    string
+	publicIdStrategy            string
+	applicationsByRepositoryUrl map[string]*sonatypeiq.ApiApplicationDTO
+	applicationRepositoryUrls   map[string]string
+	movedApplications           map[string]MovedApplication
+	reservedApplicationNames    map[string]bool
+	reservedApplicationIds      map[string]bool
+	cacheMutex                  sync.Mutex
+	concurrency                 int
+	continueOnError             bool
+	journal                     *Journal
+	report                      *Report
 }
 
 func NewNxiqServer(url string, username string, password string) *NxiqServer {
 	url = strings.TrimRight(url, "/")
 	server := &NxiqServer{
-		baseUrl:       url,
-		username:      username,
-		password:      password,
-		configuration: sonatypeiq.NewConfiguration(),
+		baseUrl:                  url,
+		username:                 username,
+		password:                 password,
+		configuration:            sonatypeiq.NewConfiguration(),
+		applicationMatchStrategy: APPLICATION_MATCH_BY_NAME,
+		publicIdStrategy:         PUBLIC_ID_STRATEGY_NAME,
+		movedApplications:        make(map[string]MovedApplication),
+		reservedApplicationNames: make(map[string]bool),
+		reservedApplicationIds:   make(map[string]bool),
+		concurrency:              1,
+		report:                   NewReport(),
 	}
 
 	server.configuration.Servers = sonatypeiq.ServerConfigurations{
@@ -57,6 +101,7 @@ func NewNxiqServer(url string, username string, password string) *NxiqServer {
 			Description: "Configured Sonatype Lifecycle",
 		},
 	}
+	server.configuration.HTTPClient = util.NewRetryingHttpClient(util.DefaultRetryPolicy)
 	server.apiClient = sonatypeiq.NewAPIClient(server.configuration)
 
 	c := context.WithValue(
@@ -83,6 +128,13 @@ func (s *NxiqServer) InitCache() error {
 			return err
 		}
 
+		if s.applicationMatchStrategy == APPLICATION_MATCH_BY_REPOSITORY_URL || s.publicIdStrategy == PUBLIC_ID_STRATEGY_SCM_IDENTITY {
+			err = s.cacheExistingApplicationRepositoryUrls()
+			if err != nil {
+				return err
+			}
+		}
+
 		s.cacheLoaded = true
 	}
 
@@ -94,7 +146,8 @@ func (s *NxiqServer) cacheExistingApplications() error {
 
 	apiResponse, r, err := s.apiClient.ApplicationsAPI.GetApplications(*s.apiContext).Execute()
 	if err != nil {
-		log.Error(fmt.Sprintf("Failed to load existing Applications from Sonatype IQ: %s: %v: %v", r.Status, err, r.Body))
+		err = newIqApiError("Get Applications", r, err)
+		log.Error(fmt.Sprintf("Failed to load existing Applications from Sonatype IQ: %v", err))
 		return err
 	}
 
@@ -106,12 +159,49 @@ func (s *NxiqServer) cacheExistingApplications() error {
 	return nil
 }
 
+/**
+ * Loads the source control configuration of every existing Application so that Applications can be
+ * matched by Repository URL. This is one request per Application.
+ */
+func (s *NxiqServer) cacheExistingApplicationRepositoryUrls() error {
+	s.applicationsByRepositoryUrl = make(map[string]*sonatypeiq.ApiApplicationDTO)
+	s.applicationRepositoryUrls = make(map[string]string)
+
+	log.Info(fmt.Sprintf("Loading source control configuration for %d existing Applications from Sonatype Lifecycle...", len(s.existingApplications)))
+	for _, a := range s.existingApplications {
+		scmDto, r, err := s.apiClient.SourceControlAPI.GetSourceControl1(*s.apiContext, "application", a.GetId()).Execute()
+		if err != nil {
+			if r != nil && r.StatusCode == http.StatusNotFound {
+				// No source control configured for this Application
+				continue
+			}
+			log.Error(fmt.Sprintf("Failed to load source control configuration for Application %s from Sonatype IQ: %v", a.GetPublicId(), err))
+			return err
+		}
+
+		repositoryUrl := scm.NormalizeRepositoryUrl(scmDto.GetRepositoryUrl())
+		if repositoryUrl == "" {
+			continue
+		}
+		if other, ok := s.applicationsByRepositoryUrl[repositoryUrl]; ok {
+			log.Warn(fmt.Sprintf("Applications %s and %s both use Repository URL %s - only %s will be matched", other.GetPublicId(), a.GetPublicId(), scmDto.GetRepositoryUrl(), other.GetPublicId()))
+		} else {
+			s.applicationsByRepositoryUrl[repositoryUrl] = a
+		}
+		s.applicationRepositoryUrls[a.GetId()] = repositoryUrl
+	}
+
+	log.Info(fmt.Sprintf("Loaded %d Repository URLs for existing Applications from Sonatype Lifecycle", len(s.applicationsByRepositoryUrl)))
+	return nil
+}
+
 func (s *NxiqServer) cacheExistingOrganizations() error {
 	s.existingOrganizations = make([]*sonatypeiq.ApiOrganizationDTO, 0)
 
 	apiResponse, r, err := s.apiClient.OrganizationsAPI.GetOrganizations(*s.apiContext).Execute()
 	if err != nil {
-		log.Error(fmt.Sprintf("Failed to load existing Applications from Sonatype IQ: %s: %v: %v", r.Status, err, r.Body))
+		err = newIqApiError("Get Organizations", r, err)
+		log.Error(fmt.Sprintf("Failed to load existing Organizations from Sonatype IQ: %v", err))
 		return err
 	}
 	for _, a := range apiResponse.Organizations {
@@ -122,51 +212,277 @@ func (s *NxiqServer) cacheExistingOrganizations() error {
 	return nil
 }
 
+/**
+ * Sets how existing Applications are recognized:
+ *
+ *   - APPLICATION_MATCH_BY_NAME (default) matches an Application with the same name in the same Organization
+ *   - APPLICATION_MATCH_BY_REPOSITORY_URL matches an Application with the same (normalized) Repository URL,
+ *     whatever it is called and wherever it lives - falling back to matching by name only where the
+ *     existing Application has no Repository URL
+ */
+func (s *NxiqServer) SetApplicationMatchStrategy(strategy string) error {
+	if strategy == "" {
+		strategy = APPLICATION_MATCH_BY_NAME
+	}
+	if strategy != APPLICATION_MATCH_BY_NAME && strategy != APPLICATION_MATCH_BY_REPOSITORY_URL {
+		return fmt.Errorf("unknown Application match strategy '%s' - must be %s or %s", strategy, APPLICATION_MATCH_BY_NAME, APPLICATION_MATCH_BY_REPOSITORY_URL)
+	}
+	if strategy != s.applicationMatchStrategy {
+		s.applicationMatchStrategy = strategy
+		s.cacheLoaded = false
+	}
+	return nil
+}
+
+func (s *NxiqServer) ApplicationMatchStrategy() string {
+	return s.applicationMatchStrategy
+}
+
+/**
+ * Sets how the public IDs of new Applications are chosen:
+ *
+ *   - PUBLIC_ID_STRATEGY_NAME (default) uses the safe ID of the Application, suffixed `-N` on a collision
+ *   - PUBLIC_ID_STRATEGY_SCM_IDENTITY uses the StableId of the Repository, which is never suffixed - an
+ *     existing Application with that ID is the same Repository, so is matched wherever it lives
+ */
+func (s *NxiqServer) SetPublicIdStrategy(strategy string) error {
+	if strategy == "" {
+		strategy = PUBLIC_ID_STRATEGY_NAME
+	}
+	if strategy != PUBLIC_ID_STRATEGY_NAME && strategy != PUBLIC_ID_STRATEGY_SCM_IDENTITY {
+		return fmt.Errorf("unknown public ID strategy '%s' - must be %s or %s", strategy, PUBLIC_ID_STRATEGY_NAME, PUBLIC_ID_STRATEGY_SCM_IDENTITY)
+	}
+	if strategy != s.publicIdStrategy {
+		s.publicIdStrategy = strategy
+		s.cacheLoaded = false
+	}
+	return nil
+}
+
+func (s *NxiqServer) PublicIdStrategy() string {
+	return s.publicIdStrategy
+}
+
+// publicIdFor is the public ID an Application is to be created with, before any collision is resolved.
+func (s *NxiqServer) publicIdFor(app scm.Application) string {
+	if s.publicIdStrategy == PUBLIC_ID_STRATEGY_SCM_IDENTITY {
+		return app.StableId()
+	}
+	return app.SafeId()
+}
+
+// MovedApplications returns the existing Applications that were matched outside of the Organization
+// the SCM structure places them in.
+func (s *NxiqServer) MovedApplications() []MovedApplication {
+	moved := make([]MovedApplication, 0, len(s.movedApplications))
+	for _, m := range s.movedApplications {
+		moved = append(moved, m)
+	}
+	sort.Slice(moved, func(i, j int) bool {
+		return moved[i].PublicId < moved[j].PublicId
+	})
+	return moved
+}
+
+// SetConcurrency sets how many Applications are created and configured in parallel. Organizations are
+// always created one at a time, before any of the Applications within them.
+func (s *NxiqServer) SetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	s.concurrency = concurrency
+}
+
+// SetContinueOnError records a failure to create or update an Organization or Application in the Report
+// and carries on with the rest, rather than stopping. Nothing beneath a failed Organization is attempted.
+func (s *NxiqServer) SetContinueOnError(continueOnError bool) {
+	s.continueOnError = continueOnError
+}
+
+// Report returns the successes and failures of this run.
+func (s *NxiqServer) Report() *Report {
+	return s.report
+}
+
+// SetJournal records everything subsequently created or updated in the supplied Journal, and skips
+// anything the Journal shows a previous run already completed.
+func (s *NxiqServer) SetJournal(journal *Journal) {
+	s.journal = journal
+}
+
+func (s *NxiqServer) Journal() *Journal {
+	return s.journal
+}
+
+// ImportSource holds the Organizations and Applications loaded from one SCM, to be imported beneath RootOrganization.
+type ImportSource struct {
+	Name             string
+	OrgContents      scm.OrgContents
+	RootOrganization *sonatypeiq.ApiOrganizationDTO
+	ScmConfig        *scm.ScmConfiguration
+}
+
 func (s *NxiqServer) ApplyOrgContents(orgContent scm.OrgContents, rootOrganization *sonatypeiq.ApiOrganizationDTO, scmConfig *scm.ScmConfiguration) error {
-	for _, o := range orgContent.Organizations {
-		org, err := s.CreateOrganization(o, *rootOrganization.Id, true, scmConfig)
+	return s.ApplySources([]ImportSource{{OrgContents: orgContent, RootOrganization: rootOrganization, ScmConfig: scmConfig}})
+}
+
+// ApplySources applies each source in turn, as ApplyOrgContents does for one.
+func (s *NxiqServer) ApplySources(sources []ImportSource) error {
+	err := checkSourcesDistinct(sources)
+	if err != nil {
+		return err
+	}
+
+	pool := util.NewWorkerPool(s.concurrency)
+	for _, source := range sources {
+		for _, o := range source.OrgContents.Organizations {
+			err := s.applyOrganization(o, *source.RootOrganization.Id, "", source.ScmConfig, pool)
+			if err != nil {
+				pool.Wait()
+				return err
+			}
+		}
+	}
+
+	return pool.Wait()
+}
+
+// checkSourcesDistinct ensures no two sources load the same SCM Organization - which would otherwise be
+// indistinguishable in the journal and report.
+func checkSourcesDistinct(sources []ImportSource) error {
+	loadedBy := make(map[string]string)
+	for _, source := range sources {
+		for _, o := range source.OrgContents.Organizations {
+			key := scmKey("", o.ScmProvider, o.Name)
+			if other, ok := loadedBy[key]; ok {
+				return fmt.Errorf("%s is loaded by both %s and %s - exclude it from one of them", key, other, source.Name)
+			}
+			loadedBy[key] = source.Name
+		}
+	}
+	return nil
+}
+
+/**
+ * Creates (or reuses) the Organization beneath parentOrgId, then its Applications and then
+ * recursively all of its Sub-Organizations - to any depth.
+ *
+ * SCM configuration is only applied to Organizations the SCM integration has flagged.
+ *
+ * Applications are submitted to `pool` once their Organization exists.
+ */
+func (s *NxiqServer) applyOrganization(o scm.Organization, parentOrgId string, parentKey string, scmConfig *scm.ScmConfiguration, pool *util.WorkerPool) error {
+	key := scmKey(parentKey, o.ScmProvider, o.Name)
+
+	var org *sonatypeiq.ApiOrganizationDTO
+	if completed := s.journal.Completed(key); completed != nil {
+		log.Debug(fmt.Sprintf("Organization %s already completed in a previous run - %s", o.SafeName(), completed.IqId))
+		org = &sonatypeiq.ApiOrganizationDTO{Id: &completed.IqId, Name: &completed.Name}
+	} else {
+		existingOrg, err := s.OrganizationExists(o, parentOrgId)
 		if err != nil {
 			return err
 		}
 
-		err = s.createAppsInOrg(org, o.Applications)
+		org, err = s.CreateOrganization(o, parentOrgId, o.ApplyScmConfiguration && scmConfig != nil, scmConfig)
+		if err != nil {
+			if !s.continueOnError {
+				return err
+			}
+			log.Error(fmt.Sprintf("Failed to create Organization %s - skipping everything within it: %v", key, err))
+			s.report.RecordFailure(PLAN_ENTITY_ORGANIZATION, key, o.Name, err)
+			s.skipOrganizationContents(o, key)
+			return nil
+		}
+		log.Debug(fmt.Sprintf("Created Organization %s - %s", o.SafeName(), *org.Id))
+		s.report.RecordSuccess(PLAN_ENTITY_ORGANIZATION, key, org.GetName(), journalAction(existingOrg != nil), *org.Id)
+
+		err = s.journal.Record(JournalEntry{
+			Key:    key,
+			Entity: PLAN_ENTITY_ORGANIZATION,
+			Action: journalAction(existingOrg != nil),
+			IqId:   *org.Id,
+			Name:   org.GetName(),
+		})
 		if err != nil {
 			return err
 		}
+	}
 
-		if len(o.SubOrganizations) > 0 {
-			for _, so := range o.SubOrganizations {
-				subOrg, err := s.CreateOrganization(so, *org.Id, false, nil)
-				if err != nil {
-					return err
-				}
-				log.Debug(fmt.Sprintf("Created Organization %s - %s", so.SafeName(), *subOrg.Id))
+	for _, a := range o.Applications {
+		err := pool.Submit(func() error {
+			return s.createAppInOrg(org, key, a)
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-				err = s.createAppsInOrg(subOrg, so.Applications)
-				if err != nil {
-					return err
-				}
-			}
+	for _, so := range o.SubOrganizations {
+		err := s.applyOrganization(so, *org.Id, key, scmConfig, pool)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (s *NxiqServer) createAppsInOrg(org *sonatypeiq.ApiOrganizationDTO, apps []scm.Application) error {
-	if len(apps) > 0 {
-		for _, a := range apps {
-			app, scm, err := s.CreateApplication(a, *org.Id)
-			if err != nil {
-				return err
-			}
-			log.Debug(fmt.Sprintf("Created Application %s - %s", a.SafeName(), *app.Id))
-			if scm != nil {
-				s.scheduleSourceStageScan(app, a.DefaultBranch)
-			}
+// skipOrganizationContents records everything within an Organization that failed as skipped.
+func (s *NxiqServer) skipOrganizationContents(o scm.Organization, key string) {
+	for _, a := range o.Applications {
+		s.report.RecordSkipped(PLAN_ENTITY_APPLICATION, scmKey(key, "", a.Name), a.Name, key)
+	}
+	for _, so := range o.SubOrganizations {
+		soKey := scmKey(key, so.ScmProvider, so.Name)
+		s.report.RecordSkipped(PLAN_ENTITY_ORGANIZATION, soKey, so.Name, key)
+		s.skipOrganizationContents(so, soKey)
+	}
+}
+
+func (s *NxiqServer) createAppInOrg(org *sonatypeiq.ApiOrganizationDTO, orgKey string, a scm.Application) error {
+	key := scmKey(orgKey, "", a.Name)
+	if completed := s.journal.Completed(key); completed != nil {
+		log.Debug(fmt.Sprintf("Application %s already completed in a previous run - %s", a.SafeName(), completed.IqId))
+		return nil
+	}
+
+	existingApp, err := s.ApplicationExists(a, *org.Id)
+	if err != nil {
+		return err
+	}
+
+	app, scm, err := s.CreateApplication(a, *org.Id)
+	if err != nil {
+		if !s.continueOnError {
+			return err
 		}
+		log.Error(fmt.Sprintf("Failed to create Application %s: %v", key, err))
+		s.report.RecordFailure(PLAN_ENTITY_APPLICATION, key, a.Name, err)
+		return nil
 	}
-	return nil
+	log.Debug(fmt.Sprintf("Created Application %s - %s", a.SafeName(), *app.Id))
+	if scm != nil {
+		s.scheduleSourceStageScan(app, a.DefaultBranch)
+	}
+	s.report.RecordSuccess(PLAN_ENTITY_APPLICATION, key, app.GetName(), journalAction(existingApp != nil), *app.Id)
+
+	return s.journal.Record(JournalEntry{
+		Key:           key,
+		Entity:        PLAN_ENTITY_APPLICATION,
+		Action:        journalAction(existingApp != nil),
+		IqId:          *app.Id,
+		Name:          app.GetName(),
+		PublicId:      app.GetPublicId(),
+		RepositoryUrl: a.RepositoryUrl,
+	})
+}
+
+func journalAction(existed bool) string {
+	if existed {
+		return PLAN_ACTION_UPDATE
+	}
+	return PLAN_ACTION_CREATE
 }
 
 func (s *NxiqServer) scheduleSourceStageScan(app *sonatypeiq.ApiApplicationDTO, defaultBranchName *string) {
@@ -227,6 +543,9 @@ func (s *NxiqServer) OrganizationExists(org scm.Organization, parentOrgId string
 	if err != nil {
 		log.Fatalln(err)
 	}
+
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
 	for _, existingOrg := range s.existingOrganizations {
 		if *existingOrg.Name == org.SafeName() && *existingOrg.ParentOrganizationId == parentOrgId {
 			return existingOrg, nil
@@ -237,12 +556,13 @@ func (s *NxiqServer) OrganizationExists(org scm.Organization, parentOrgId string
 
 func (s *NxiqServer) createOrganization(org scm.Organization, parentOrgId string) (*sonatypeiq.ApiOrganizationDTO, error) {
 	orgName := s.getUniqueOrganizationId(org.SafeName())
+	operation := fmt.Sprintf("Create Organization %s", org.SafeName())
 
 	var err error
 	var httpResponse *http.Response
 	var attemptCount = 0
 	var createdOrg *sonatypeiq.ApiOrganizationDTO
-	for httpResponse == nil || httpResponse.StatusCode != http.StatusOK {
+	for {
 		createdOrg, httpResponse, err = s.apiClient.OrganizationsAPI.AddOrganization(*s.apiContext).ApiOrganizationDTO(sonatypeiq.ApiOrganizationDTO{
 			Name:                 &orgName,
 			ParentOrganizationId: &parentOrgId,
@@ -250,6 +570,15 @@ func (s *NxiqServer) createOrganization(org scm.Organization, parentOrgId string
 
 		attemptCount += 1
 
+		if httpResponse == nil {
+			// Failed even after retrying - see util.RetryPolicy
+			return nil, newIqApiError(operation, httpResponse, err)
+		}
+
+		if httpResponse.StatusCode == http.StatusOK {
+			break
+		}
+
 		if httpResponse.StatusCode == http.StatusBadRequest {
 			// We possibly had a colision - check response body
 			defer httpResponse.Body.Close()
@@ -269,13 +598,17 @@ func (s *NxiqServer) createOrganization(org scm.Organization, parentOrgId string
 			}
 		}
 
-		if attemptCount > 2 && err != nil {
-			log.Debug(fmt.Sprintf("Error when calling `OrganizationsAPI.AddOrganization` on attempt %d: %v\n", attemptCount, err))
-			log.Debug(fmt.Sprintf("Full HTTP response: %v\n", httpResponse))
-			return nil, err
-		}
+		// Anything other than a name collision is not retried here - the request may well have been
+		// acted on, and util.RetryPolicy has already retried where it safely could
+		log.Debug(fmt.Sprintf("Error when calling `OrganizationsAPI.AddOrganization` on attempt %d: %v\n", attemptCount, err))
+		log.Debug(fmt.Sprintf("Full HTTP response: %v\n", httpResponse))
+		return nil, newIqApiError(operation, httpResponse, err)
 	}
 
+	s.cacheMutex.Lock()
+	s.existingOrganizations = append(s.existingOrganizations, createdOrg)
+	s.cacheMutex.Unlock()
+
 	return createdOrg, nil
 }
 
@@ -296,7 +629,7 @@ func (s *NxiqServer) SetOrganizationScmConfiguration(org *sonatypeiq.ApiOrganiza
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error when calling `SourceControlAPI.AddSourceControl``: %v\n", err)
 		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
-		return err
+		return newIqApiError("Set Organization SCM configuration", r, err)
 	}
 	return nil
 }
@@ -318,7 +651,7 @@ func (s *NxiqServer) UpdateOrganizationScmConfiguration(org *sonatypeiq.ApiOrgan
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error when calling `SourceControlAPI.UpdateSourceControl``: %v\n", err)
 		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
-		return err
+		return newIqApiError("Update Organization SCM configuration", r, err)
 	}
 	return nil
 }
@@ -330,27 +663,17 @@ func (s *NxiqServer) CreateApplication(app scm.Application, parentOrgId string)
 		return nil, nil, err
 	}
 
-	var scmDto *sonatypeiq.ApiSourceControlDTO
 	if existingApp != nil {
 		// Update SCM Configuration
 		if app.IsRepositoryUrlPermitted() && app.IsBranchNamePermitted() {
-			scmDto, r, err := s.apiClient.SourceControlAPI.UpdateSourceControl(*s.apiContext, "application", *existingApp.Id).ApiSourceControlDTO(sonatypeiq.ApiSourceControlDTO{
-				RepositoryUrl:                   &app.RepositoryUrl,
-				BaseBranch:                      app.DefaultBranch,
-				EnablePullRequests:              nil,
-				RemediationPullRequestsEnabled:  nil,
-				PullRequestCommentingEnabled:    nil,
-				SourceControlEvaluationsEnabled: nil,
-				SshEnabled:                      nil,
-			}).Execute()
+			scmDto, err := s.updateApplicationSourceControl(*existingApp.Id, app)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error when calling `SourceControlAPI.UpdateSourceControl``: %v\n", err)
-				fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
 				return nil, nil, err
 			}
 			return existingApp, scmDto, nil
 		} else {
 			log.Warn(fmt.Sprintf("Application %s has an unsupported Default Branch or Repository URL '%s' and will not have SCM configuration saved into Sonatype", app.Name, app.RepositoryUrl))
+			return existingApp, nil, nil
 		}
 	}
 
@@ -368,18 +691,8 @@ func (s *NxiqServer) CreateApplication(app scm.Application, parentOrgId string)
 				app.RepositoryUrl, app.IsRepositoryUrlPermitted(), *app.DefaultBranch, app.IsBranchNamePermitted(),
 			),
 		)
-		scmDto, r, err := s.apiClient.SourceControlAPI.AddSourceControl(*s.apiContext, "application", *createdApp.Id).ApiSourceControlDTO(sonatypeiq.ApiSourceControlDTO{
-			RepositoryUrl:                   &app.RepositoryUrl,
-			BaseBranch:                      app.DefaultBranch,
-			EnablePullRequests:              nil,
-			RemediationPullRequestsEnabled:  nil,
-			PullRequestCommentingEnabled:    nil,
-			SourceControlEvaluationsEnabled: nil,
-			SshEnabled:                      nil,
-		}).Execute()
+		scmDto, err := s.addApplicationSourceControl(*createdApp.Id, app)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error when calling `SourceControlAPI.AddSourceControl``: %v\n", err)
-			fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
 			return nil, nil, err
 		}
 		return createdApp, scmDto, nil
@@ -387,31 +700,143 @@ func (s *NxiqServer) CreateApplication(app scm.Application, parentOrgId string)
 		log.Warn(fmt.Sprintf("Application %s has an unsupported Default Branch or Repository URL '%s' and will not have SCM configuration saved into Sonatype", app.Name, app.RepositoryUrl))
 	}
 
-	return createdApp, scmDto, nil
+	return createdApp, nil, nil
+}
+
+func (s *NxiqServer) addApplicationSourceControl(applicationId string, app scm.Application) (*sonatypeiq.ApiSourceControlDTO, error) {
+	scmDto, r, err := s.apiClient.SourceControlAPI.AddSourceControl(*s.apiContext, "application", applicationId).ApiSourceControlDTO(sonatypeiq.ApiSourceControlDTO{
+		RepositoryUrl:                   &app.RepositoryUrl,
+		BaseBranch:                      app.DefaultBranch,
+		EnablePullRequests:              nil,
+		RemediationPullRequestsEnabled:  nil,
+		PullRequestCommentingEnabled:    nil,
+		SourceControlEvaluationsEnabled: nil,
+		SshEnabled:                      nil,
+	}).Execute()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `SourceControlAPI.AddSourceControl``: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+		return nil, newIqApiError("Add Application source control", r, err)
+	}
+	return scmDto, nil
+}
+
+func (s *NxiqServer) updateApplicationSourceControl(applicationId string, app scm.Application) (*sonatypeiq.ApiSourceControlDTO, error) {
+	scmDto, r, err := s.apiClient.SourceControlAPI.UpdateSourceControl(*s.apiContext, "application", applicationId).ApiSourceControlDTO(sonatypeiq.ApiSourceControlDTO{
+		RepositoryUrl:                   &app.RepositoryUrl,
+		BaseBranch:                      app.DefaultBranch,
+		EnablePullRequests:              nil,
+		RemediationPullRequestsEnabled:  nil,
+		PullRequestCommentingEnabled:    nil,
+		SourceControlEvaluationsEnabled: nil,
+		SshEnabled:                      nil,
+	}).Execute()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when calling `SourceControlAPI.UpdateSourceControl``: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+		return nil, newIqApiError("Update Application source control", r, err)
+	}
+	return scmDto, nil
 }
 
 func (s *NxiqServer) ApplicationExists(app scm.Application, parentOrgId string) (*sonatypeiq.ApiApplicationDTO, error) {
+	existingApp, _, err := s.findApplication(app, parentOrgId)
+	return existingApp, err
+}
+
+/**
+ * As ApplicationExists, but also says what the existing Application was matched by (e.g. "Repository URL"),
+ * for explaining why an Application in another Organization was matched.
+ */
+func (s *NxiqServer) findApplication(app scm.Application, parentOrgId string) (*sonatypeiq.ApiApplicationDTO, string, error) {
 	err := s.InitCache()
 	if err != nil {
 		log.Fatalln(err)
 	}
+
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	if s.publicIdStrategy == PUBLIC_ID_STRATEGY_SCM_IDENTITY {
+		stableId := app.StableId()
+		for _, existingApp := range s.existingApplications {
+			if existingApp.GetPublicId() == stableId {
+				knownUrl := s.applicationRepositoryUrls[existingApp.GetId()]
+				if knownUrl != "" && knownUrl != scm.NormalizeRepositoryUrl(app.RepositoryUrl) {
+					return nil, "", &stableIdConflictError{PublicId: stableId, Name: existingApp.GetName(), RepositoryUrl: knownUrl}
+				}
+				if existingApp.GetOrganizationId() != parentOrgId {
+					s.recordMovedApplication(existingApp, app, parentOrgId)
+				}
+				return existingApp, fmt.Sprintf("ID %s", stableId), nil
+			}
+		}
+	}
+	if s.applicationMatchStrategy == APPLICATION_MATCH_BY_REPOSITORY_URL {
+		existingApp := s.applicationsByRepositoryUrl[scm.NormalizeRepositoryUrl(app.RepositoryUrl)]
+		if existingApp != nil {
+			if existingApp.GetOrganizationId() != parentOrgId {
+				s.recordMovedApplication(existingApp, app, parentOrgId)
+			}
+			return existingApp, "Repository URL", nil
+		}
+	}
+
 	for _, existingApp := range s.existingApplications {
 		if *existingApp.Name == app.SafeName() && *existingApp.OrganizationId == parentOrgId {
-			return existingApp, nil
+			if s.applicationMatchStrategy == APPLICATION_MATCH_BY_REPOSITORY_URL && s.applicationRepositoryUrls[existingApp.GetId()] != "" {
+				// Same name, but a different repository
+				continue
+			}
+			return existingApp, "name", nil
 		}
 	}
-	return nil, nil
+	return nil, "", nil
+}
+
+// stableIdConflictError is returned when an existing Application has the stable ID of a Repository but is
+// for a different Repository - it is never taken over.
+type stableIdConflictError struct {
+	PublicId      string
+	Name          string
+	RepositoryUrl string
+}
+
+func (e *stableIdConflictError) Error() string {
+	return fmt.Sprintf("Application %s already has ID %s but is for Repository %s - it will not be taken over", e.Name, e.PublicId, e.RepositoryUrl)
+}
+
+func (s *NxiqServer) recordMovedApplication(existingApp *sonatypeiq.ApiApplicationDTO, app scm.Application, expectedOrgId string) {
+	if _, ok := s.movedApplications[existingApp.GetId()]; !ok {
+		log.Warn(fmt.Sprintf("Application %s for %s exists in a different Organization (%s) - it will be updated there rather than duplicated", existingApp.GetPublicId(), app.RepositoryUrl, s.organizationById(existingApp.GetOrganizationId()).GetName()))
+	}
+	s.movedApplications[existingApp.GetId()] = MovedApplication{
+		ApplicationId:          existingApp.GetId(),
+		PublicId:               existingApp.GetPublicId(),
+		Name:                   existingApp.GetName(),
+		RepositoryUrl:          app.RepositoryUrl,
+		OrganizationId:         existingApp.GetOrganizationId(),
+		OrganizationName:       s.organizationById(existingApp.GetOrganizationId()).GetName(),
+		ExpectedOrganizationId: expectedOrgId,
+	}
 }
 
 func (s *NxiqServer) createApplication(app scm.Application, parentOrgId string) (*sonatypeiq.ApiApplicationDTO, error) {
-	appId := s.getUniqueSafeApplicationId(app.SafeId())
-	appName := app.SafeName()
+	operation := fmt.Sprintf("Create Application %s", app.SafeName())
+	appName, appId, err := s.reserveApplicationNameAndId(app.SafeName(), s.publicIdFor(app), 0)
+	if err != nil {
+		return nil, newIqApiError(operation, nil, err)
+	}
+	created := false
+	defer func() {
+		if !created {
+			s.releaseApplicationNameAndId(appName, appId)
+		}
+	}()
 
-	var err error
 	var httpResponse *http.Response
 	var attemptCount = 0
 	var createdApp *sonatypeiq.ApiApplicationDTO
-	for httpResponse == nil || httpResponse.StatusCode != http.StatusOK {
+	for {
 		createdApp, httpResponse, err = s.apiClient.ApplicationsAPI.AddApplication(*s.apiContext).ApiApplicationDTO(sonatypeiq.ApiApplicationDTO{
 			PublicId:       &appId,
 			Name:           &appName,
@@ -420,6 +845,15 @@ func (s *NxiqServer) createApplication(app scm.Application, parentOrgId string)
 
 		attemptCount += 1
 
+		if httpResponse == nil {
+			// Failed even after retrying - see util.RetryPolicy
+			return nil, newIqApiError(operation, httpResponse, err)
+		}
+
+		if httpResponse.StatusCode == http.StatusOK {
+			break
+		}
+
 		if httpResponse.StatusCode == http.StatusBadRequest {
 			// We possibly had a colision - check response body
 			defer httpResponse.Body.Close()
@@ -431,35 +865,125 @@ func (s *NxiqServer) createApplication(app scm.Application, parentOrgId string)
 			responseBody := string(b)
 			log.Debug(fmt.Sprintf("Response Body: %s", responseBody))
 
+			if strings.HasSuffix(responseBody, "as an ID.") && s.publicIdStrategy == PUBLIC_ID_STRATEGY_SCM_IDENTITY {
+				// The stable ID cannot be bumped
+				return nil, newIqApiError(operation, httpResponse, applicationIdCreatedElsewhere(appId))
+			}
+
 			if strings.HasSuffix(responseBody, "as an ID.") || strings.HasSuffix(responseBody, "as a name.") {
 				// ID or Name had a conflict
-				appId = fmt.Sprintf("%s-%d", app.SafeId(), attemptCount)
-				appName = fmt.Sprintf("%s-%d", app.SafeName(), attemptCount)
+				nextName, nextId, err := s.reserveApplicationNameAndId(app.SafeName(), s.publicIdFor(app), attemptCount)
+				if err != nil {
+					return nil, newIqApiError(operation, nil, err)
+				}
+				appName, appId = nextName, nextId
 				log.Debug(fmt.Sprintf("Bumped Application ID and Name to be %s, %s", appId, appName))
 				continue
 			}
 		}
 
-		if attemptCount > 2 && err != nil {
-			log.Debug(fmt.Sprintf("Error when calling `ApplicationsAPI.AddApplication` on attempt %d: %v\n", attemptCount, err))
-			log.Debug(fmt.Sprintf("Full HTTP response: %v\n", httpResponse))
-			return nil, err
-		}
+		// Anything other than a name or ID collision is not retried here - the request may well have
+		// been acted on, and util.RetryPolicy has already retried where it safely could
+		log.Debug(fmt.Sprintf("Error when calling `ApplicationsAPI.AddApplication` on attempt %d: %v\n", attemptCount, err))
+		log.Debug(fmt.Sprintf("Full HTTP response: %v\n", httpResponse))
+		return nil, newIqApiError(operation, httpResponse, err)
+	}
+
+	created = true
+	s.cacheMutex.Lock()
+	s.existingApplications = append(s.existingApplications, createdApp)
+	if repositoryUrl := scm.NormalizeRepositoryUrl(app.RepositoryUrl); s.applicationsByRepositoryUrl != nil && repositoryUrl != "" {
+		s.applicationsByRepositoryUrl[repositoryUrl] = createdApp
+		s.applicationRepositoryUrls[createdApp.GetId()] = repositoryUrl
 	}
+	s.cacheMutex.Unlock()
 
 	return createdApp, nil
 }
 
-func (s *NxiqServer) getUniqueSafeApplicationId(id string) string {
-	for _, existinApp := range s.existingApplications {
-		if *existinApp.Id == id {
-			return fmt.Sprintf("%s-1", id)
+/**
+ * Returns the first name and ID, bumped with a `-N` suffix from `attempt` onwards, that neither an
+ * existing Application nor another in-flight creation is using - and reserves them, so that
+ * Applications being created in parallel cannot collide with each other.
+ *
+ * Under PUBLIC_ID_STRATEGY_SCM_IDENTITY only the name is bumped - the ID being in use when first
+ * reserved (`attempt` 0) is an error, as another Application (or a parallel run) has already been
+ * created for the Repository. Later attempts already hold the ID, so only bump the name.
+ */
+func (s *NxiqServer) reserveApplicationNameAndId(name string, id string, attempt int) (string, string, error) {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+
+	bump := func(in string, attempt int) string {
+		if attempt == 0 {
+			return in
 		}
+		return fmt.Sprintf("%s-%d", in, attempt)
 	}
-	return id
+
+	fixedId := s.publicIdStrategy == PUBLIC_ID_STRATEGY_SCM_IDENTITY
+	if fixedId && attempt == 0 && s.applicationIdInUse(id) {
+		return "", "", applicationIdCreatedElsewhere(id)
+	}
+
+	candidateName, candidateId := bump(name, attempt), id
+	if !fixedId {
+		candidateId = bump(id, attempt)
+	}
+	for s.applicationNameInUse(candidateName) || (!fixedId && s.applicationIdInUse(candidateId)) {
+		attempt++
+		candidateName = bump(name, attempt)
+		if !fixedId {
+			candidateId = bump(id, attempt)
+		}
+	}
+
+	s.reservedApplicationNames[candidateName] = true
+	s.reservedApplicationIds[candidateId] = true
+	return candidateName, candidateId, nil
+}
+
+/**
+ * Releases a reservation made by reserveApplicationNameAndId for an Application that was not created.
+ */
+func (s *NxiqServer) releaseApplicationNameAndId(name string, id string) {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	delete(s.reservedApplicationNames, name)
+	delete(s.reservedApplicationIds, id)
+}
+
+func applicationIdCreatedElsewhere(id string) error {
+	return fmt.Errorf("Application ID %s is already in use - an Application for this Repository was created elsewhere (perhaps by a parallel run); re-run to update it instead", id)
+}
+
+func (s *NxiqServer) applicationNameInUse(name string) bool {
+	if s.reservedApplicationNames[name] {
+		return true
+	}
+	for _, existingApp := range s.existingApplications {
+		if existingApp.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *NxiqServer) applicationIdInUse(id string) bool {
+	if s.reservedApplicationIds[id] {
+		return true
+	}
+	for _, existingApp := range s.existingApplications {
+		if existingApp.GetPublicId() == id {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *NxiqServer) getUniqueOrganizationId(id string) string {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
 	for _, existingOrg := range s.existingOrganizations {
 		if *existingOrg.Id == id {
 			return fmt.Sprintf("%s-1", id)
@@ -0,0 +1,317 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	sonatypeiq "github.com/sonatype-nexus-community/nexus-iq-api-client-go"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/scm"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeIq is an in-memory Sonatype Lifecycle that enforces unique Organization names and unique
+// Application names and IDs, as the real one does. Creating anything named in `broken` fails. Every
+// create request is recorded in `posts`, in the order received.
+type fakeIq struct {
+	mu            sync.Mutex
+	organizations []sonatypeiq.ApiOrganizationDTO
+	applications  []sonatypeiq.ApiApplicationDTO
+	broken        map[string]bool
+	posts         []string
+}
+
+func (f *fakeIq) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v2/organizations":
+		json.NewEncoder(w).Encode(sonatypeiq.ApiOrganizationListDTO{Organizations: f.organizations})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v2/applications":
+		json.NewEncoder(w).Encode(sonatypeiq.ApiApplicationListDTO{Applications: f.applications})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v2/organizations":
+		var org sonatypeiq.ApiOrganizationDTO
+		json.NewDecoder(r.Body).Decode(&org)
+		f.posts = append(f.posts, org.GetName())
+		if f.broken[org.GetName()] {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Something broke")
+			return
+		}
+		for _, o := range f.organizations {
+			if o.GetName() == org.GetName() {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Organization name %s is already used as a name.", org.GetName())
+				return
+			}
+		}
+		id := fmt.Sprintf("org-%d", len(f.organizations))
+		org.Id = &id
+		f.organizations = append(f.organizations, org)
+		json.NewEncoder(w).Encode(org)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v2/applications":
+		var app sonatypeiq.ApiApplicationDTO
+		json.NewDecoder(r.Body).Decode(&app)
+		f.posts = append(f.posts, app.GetName())
+		if f.broken[app.GetName()] {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "Something broke")
+			return
+		}
+		for _, a := range f.applications {
+			if a.GetPublicId() == app.GetPublicId() {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Application ID %s is already used as an ID.", app.GetPublicId())
+				return
+			}
+			if a.GetName() == app.GetName() {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Application name %s is already used as a name.", app.GetName())
+				return
+			}
+		}
+		id := fmt.Sprintf("app-%d", len(f.applications))
+		app.Id = &id
+		f.applications = append(f.applications, app)
+		json.NewEncoder(w).Encode(app)
+
+	default:
+		// Source control configuration and evaluations
+		fmt.Fprint(w, "{}")
+	}
+}
+
+func TestApplyOrgContentsConcurrently(t *testing.T) {
+	rootId, rootName := "ROOT_ORGANIZATION_ID", "Root Organization"
+	fake := &fakeIq{organizations: []sonatypeiq.ApiOrganizationDTO{{Id: &rootId, Name: &rootName}}}
+	iqServer := httptest.NewServer(fake)
+	defer iqServer.Close()
+
+	// Every project has a repository called "api", so names collide across Organizations
+	orgContents := scm.OrgContents{}
+	for i := 0; i < 5; i++ {
+		project := scm.Organization{Name: fmt.Sprintf("project-%d", i), ScmProvider: scm.SCM_TYPE_GITHUB}
+		project.Applications = append(project.Applications, scm.Application{Name: "api", RepositoryUrl: fmt.Sprintf("https://github.com/acme/project-%d-api", i), DefaultBranch: strPtr("main")})
+		for j := 0; j < 10; j++ {
+			name := fmt.Sprintf("repo-%d-%d", i, j)
+			project.Applications = append(project.Applications, scm.Application{Name: name, RepositoryUrl: "https://github.com/acme/" + name, DefaultBranch: strPtr("main")})
+		}
+		orgContents.Organizations = append(orgContents.Organizations, project)
+	}
+
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	s.SetConcurrency(8)
+	root := s.ValidateOrganizationByName(rootName)
+	assert.NoError(t, s.ApplyOrgContents(orgContents, root, nil))
+
+	assert.Len(t, fake.organizations, 6)
+	assert.Len(t, fake.applications, 55)
+
+	publicIds := make(map[string]bool)
+	for _, a := range fake.applications {
+		publicIds[a.GetPublicId()] = true
+	}
+	assert.Len(t, publicIds, 55)
+	for _, id := range []string{"api", "api-1", "api-2", "api-3", "api-4"} {
+		assert.True(t, publicIds[id], id)
+	}
+
+	// The cache reflects everything that was created
+	assert.Len(t, s.existingOrganizations, 6)
+	assert.Len(t, s.existingApplications, 55)
+}
+
+func TestApplyOrgContentsContinuesOnError(t *testing.T) {
+	rootId, rootName := "ROOT_ORGANIZATION_ID", "Root Organization"
+	fake := &fakeIq{
+		organizations: []sonatypeiq.ApiOrganizationDTO{{Id: &rootId, Name: &rootName}},
+		broken:        map[string]bool{"bad": true, "broken": true},
+	}
+	iqServer := httptest.NewServer(fake)
+	defer iqServer.Close()
+
+	orgContents := scm.OrgContents{
+		Organizations: []scm.Organization{
+			{
+				Name:        "bad",
+				ScmProvider: scm.SCM_TYPE_GITHUB,
+				Applications: []scm.Application{
+					{Name: "never", RepositoryUrl: "https://github.com/bad/never", DefaultBranch: strPtr("main")},
+				},
+				SubOrganizations: []scm.Organization{
+					{
+						Name:        "child",
+						ScmProvider: scm.SCM_TYPE_GITHUB,
+						Applications: []scm.Application{
+							{Name: "also-never", RepositoryUrl: "https://github.com/bad/also-never", DefaultBranch: strPtr("main")},
+						},
+					},
+				},
+			},
+			{
+				Name:        "good",
+				ScmProvider: scm.SCM_TYPE_GITHUB,
+				Applications: []scm.Application{
+					{Name: "broken", RepositoryUrl: "https://github.com/good/broken", DefaultBranch: strPtr("main")},
+					{Name: "fine", RepositoryUrl: "https://github.com/good/fine", DefaultBranch: strPtr("main")},
+				},
+			},
+		},
+	}
+
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	s.SetContinueOnError(true)
+	root := s.ValidateOrganizationByName(rootName)
+	assert.NoError(t, s.ApplyOrgContents(orgContents, root, nil))
+
+	report := s.Report()
+	assert.True(t, report.HasFailures())
+	assert.Len(t, report.Succeeded, 2)
+	assert.Len(t, report.Failed, 2)
+	assert.Len(t, report.Skipped, 3)
+
+	assert.Equal(t, "github:/bad", report.Failed[0].Key)
+	assert.Equal(t, http.StatusInternalServerError, report.Failed[0].StatusCode)
+	assert.Equal(t, "Something broke", report.Failed[0].ResponseBody)
+	assert.Equal(t, "github:/good/broken", report.Failed[1].Key)
+	assert.Equal(t, "github:/bad/child/also-never", report.Skipped[2].Key)
+
+	// A failed create is never re-sent - it may have been acted on
+	assert.Equal(t, 1, countPosts(fake, "bad"))
+	assert.Equal(t, 1, countPosts(fake, "broken"))
+
+	// Without -continue-on-error the first failure stops the run
+	s = NewNxiqServer(iqServer.URL, "admin", "admin123")
+	assert.Error(t, s.ApplyOrgContents(orgContents, root, nil))
+}
+
+func countPosts(f *fakeIq, name string) int {
+	count := 0
+	for _, p := range f.posts {
+		if p == name {
+			count++
+		}
+	}
+	return count
+}
+
+func TestApplyOrgContentsWithStableIdWhenNameTaken(t *testing.T) {
+	rootId, rootName := "ROOT_ORGANIZATION_ID", "Root Organization"
+	fake := &fakeIq{organizations: []sonatypeiq.ApiOrganizationDTO{{Id: &rootId, Name: &rootName}}}
+	iqServer := httptest.NewServer(fake)
+	defer iqServer.Close()
+
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	assert.NoError(t, s.SetPublicIdStrategy(PUBLIC_ID_STRATEGY_SCM_IDENTITY))
+	root := s.ValidateOrganizationByName(rootName)
+
+	// Created after the cache was loaded, so only Sonatype Lifecycle knows the name is taken
+	otherId, otherPublicId, otherName := "app-other", "widget", "widget"
+	fake.applications = append(fake.applications, sonatypeiq.ApiApplicationDTO{Id: &otherId, PublicId: &otherPublicId, Name: &otherName, OrganizationId: &rootId})
+
+	orgContents := scm.OrgContents{Organizations: []scm.Organization{
+		{Name: "acme", ScmProvider: scm.SCM_TYPE_GITHUB, Applications: []scm.Application{
+			{Name: "widget", ScmId: "42", RepositoryUrl: "https://github.com/acme/widget", DefaultBranch: strPtr("main")},
+		}},
+	}}
+	assert.NoError(t, s.ApplyOrgContents(orgContents, root, nil))
+
+	created := fake.applications[len(fake.applications)-1]
+	assert.Equal(t, "widget-1", created.GetName())
+	assert.Equal(t, "repo-42", created.GetPublicId())
+	assert.Equal(t, []string{"acme", "widget", "widget-1"}, fake.posts)
+}
+
+func TestCreateApplicationReleasesReservationOnFailure(t *testing.T) {
+	rootId, rootName := "ROOT_ORGANIZATION_ID", "Root Organization"
+	fake := &fakeIq{
+		organizations: []sonatypeiq.ApiOrganizationDTO{{Id: &rootId, Name: &rootName}},
+		broken:        map[string]bool{"widget": true},
+	}
+	iqServer := httptest.NewServer(fake)
+	defer iqServer.Close()
+
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	assert.NoError(t, s.SetPublicIdStrategy(PUBLIC_ID_STRATEGY_SCM_IDENTITY))
+	s.ValidateOrganizationByName(rootName)
+
+	app := scm.Application{Name: "widget", ScmId: "42", RepositoryUrl: "https://github.com/acme/widget", DefaultBranch: strPtr("main")}
+	_, err := s.createApplication(app, rootId)
+	assert.Error(t, err)
+	assert.False(t, s.applicationNameInUse("widget"))
+	assert.False(t, s.applicationIdInUse("repo-42"))
+
+	// A later attempt, e.g. with -continue-on-error, is not mistaken for a parallel run
+	delete(fake.broken, "widget")
+	created, err := s.createApplication(app, rootId)
+	assert.NoError(t, err)
+	assert.Equal(t, "repo-42", created.GetPublicId())
+}
+
+func TestApplyOrgContentsNestsOrganizations(t *testing.T) {
+	rootId, rootName := "ROOT_ORGANIZATION_ID", "Root Organization"
+	fake := &fakeIq{organizations: []sonatypeiq.ApiOrganizationDTO{{Id: &rootId, Name: &rootName}}}
+	iqServer := httptest.NewServer(fake)
+	defer iqServer.Close()
+
+	orgContents := scm.OrgContents{Organizations: []scm.Organization{
+		{Name: "acme", ScmProvider: scm.SCM_TYPE_GITLAB, SubOrganizations: []scm.Organization{
+			{Name: "backend", ScmProvider: scm.SCM_TYPE_GITLAB, SubOrganizations: []scm.Organization{
+				{Name: "services", ScmProvider: scm.SCM_TYPE_GITLAB, Applications: []scm.Application{
+					{Name: "api", RepositoryUrl: "https://gitlab.com/acme/backend/services/api", DefaultBranch: strPtr("main")},
+				}},
+			}},
+			{Name: "frontend", ScmProvider: scm.SCM_TYPE_GITLAB, Applications: []scm.Application{
+				{Name: "web", RepositoryUrl: "https://gitlab.com/acme/frontend/web", DefaultBranch: strPtr("main")},
+			}},
+		}},
+	}}
+
+	s := NewNxiqServer(iqServer.URL, "admin", "admin123")
+	s.SetConcurrency(4)
+	root := s.ValidateOrganizationByName(rootName)
+	assert.NoError(t, s.ApplyOrgContents(orgContents, root, nil))
+
+	orgIds := make(map[string]string)
+	parentIds := make(map[string]string)
+	for _, o := range fake.organizations {
+		orgIds[o.GetName()] = o.GetId()
+		parentIds[o.GetName()] = o.GetParentOrganizationId()
+	}
+	assert.Len(t, fake.organizations, 5)
+	assert.Equal(t, rootId, parentIds["acme"])
+	assert.Equal(t, orgIds["acme"], parentIds["backend"])
+	assert.Equal(t, orgIds["backend"], parentIds["services"])
+	assert.Equal(t, orgIds["acme"], parentIds["frontend"])
+
+	appOrgIds := make(map[string]string)
+	for _, a := range fake.applications {
+		appOrgIds[a.GetName()] = a.GetOrganizationId()
+	}
+	assert.Equal(t, orgIds["services"], appOrgIds["api"])
+	assert.Equal(t, orgIds["frontend"], appOrgIds["web"])
+}
@@ -0,0 +1,180 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sonatypeiq "github.com/sonatype-nexus-community/nexus-iq-api-client-go"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
+const (
+	// PREFLIGHT_NAME_PREFIX begins the name of the temporary Organization and Application a write probe creates
+	PREFLIGHT_NAME_PREFIX = "bulk-scm-onboarder-preflight"
+	// ROLE_OWNER is the built-in role that can create Organizations and Applications and manage their
+	// source control configuration
+	ROLE_OWNER = "Owner"
+)
+
+/**
+ * Checks, without changing anything, that the user can onboard into the named Organization: that they can
+ * read Organizations and Applications, and which roles they hold in the Organization.
+ *
+ * Roles can be granted through groups, and custom roles can grant what Owner does, so a user holding no
+ * role directly is not failed - the detail says so. With probeWrites the permissions are also proven by
+ * creating a temporary Organization holding an Application beneath the named Organization, then deleting
+ * both.
+ */
+func (s *NxiqServer) ValidatePermissions(organizationName string, probeWrites bool) *util.Checklist {
+	checks := util.NewChecklist()
+
+	err := s.InitCache()
+	detail := fmt.Sprintf("%d Organizations and %d Applications visible", len(s.existingOrganizations), len(s.existingApplications))
+	if !checks.Check("Authenticate to Sonatype Lifecycle and read Organizations and Applications", detail, err) {
+		return checks
+	}
+
+	target := s.organizationByName(organizationName)
+	if target == nil {
+		err = fmt.Errorf("no Organization named %s is visible", organizationName)
+	}
+	if !checks.Check(fmt.Sprintf("Find target Organization %s", organizationName), target.GetId(), err) {
+		return checks
+	}
+
+	detail, err = s.describeRoles(target)
+	if !checks.Check(fmt.Sprintf("Read roles of %s in %s", s.username, organizationName), detail, err) {
+		return checks
+	}
+
+	if probeWrites {
+		s.probeWritePermissions(target, checks)
+	}
+	return checks
+}
+
+// describeRoles lists the roles granted directly to the user in (or above) the Organization.
+func (s *NxiqServer) describeRoles(org *sonatypeiq.ApiOrganizationDTO) (string, error) {
+	roles, r, err := s.apiClient.RolesAPI.GetRoles(*s.apiContext).Execute()
+	if err != nil {
+		return "", newIqApiError("Get roles", r, err)
+	}
+	roleNames := make(map[string]string)
+	for _, role := range roles.Roles {
+		roleNames[role.GetId()] = role.GetName()
+	}
+
+	memberships, r, err := s.apiClient.RoleMembershipsAPI.GetRoleMembershipsApplicationOrOrganization(*s.apiContext, "organization", org.GetId()).Execute()
+	if err != nil {
+		return "", newIqApiError("Get role memberships", r, err)
+	}
+
+	granted := make([]string, 0)
+	owner := false
+	for _, mapping := range memberships.MemberMappings {
+		for _, member := range mapping.Members {
+			if member.GetType() != "USER" || !strings.EqualFold(member.GetUserOrGroupName(), s.username) {
+				continue
+			}
+			name := roleNames[mapping.GetRoleId()]
+			owner = owner || name == ROLE_OWNER
+			if member.GetOwnerId() != "" && member.GetOwnerId() != org.GetId() {
+				name = fmt.Sprintf("%s (from %s)", name, s.organizationById(member.GetOwnerId()).GetName())
+			}
+			granted = append(granted, name)
+		}
+	}
+
+	switch {
+	case owner:
+		return strings.Join(granted, ", "), nil
+	case len(granted) > 0:
+		return fmt.Sprintf("%s - onboarding needs %s, or a custom role that can edit IQ elements; run validate -probe-writes to be sure", strings.Join(granted, ", "), ROLE_OWNER), nil
+	default:
+		return fmt.Sprintf("no role is granted to %s directly - it may be granted through a group; run validate -probe-writes to be sure", s.username), nil
+	}
+}
+
+// probeWritePermissions proves the user can create Organizations and Applications in the target Organization
+// and set their source control configuration, by doing so - then deleting what it created.
+func (s *NxiqServer) probeWritePermissions(target *sonatypeiq.ApiOrganizationDTO, checks *util.Checklist) {
+	organizationName := target.GetName()
+	probeName := fmt.Sprintf("%s-%s", PREFLIGHT_NAME_PREFIX, time.Now().UTC().Format("20060102150405"))
+	probeOrg, r, err := s.apiClient.OrganizationsAPI.AddOrganization(*s.apiContext).ApiOrganizationDTO(sonatypeiq.ApiOrganizationDTO{
+		Name:                 &probeName,
+		ParentOrganizationId: target.Id,
+	}).Execute()
+	if err != nil {
+		err = newIqApiError("Add Organization", r, err)
+	}
+	if !checks.Check(fmt.Sprintf("Create Organizations in %s", organizationName), "", err) {
+		return
+	}
+
+	var probeApp *sonatypeiq.ApiApplicationDTO
+	defer func() {
+		var err error
+		if probeApp != nil {
+			r, err = s.apiClient.ApplicationsAPI.DeleteApplication(*s.apiContext, probeApp.GetId()).Execute()
+			if err != nil {
+				err = newIqApiError("Delete Application", r, err)
+			}
+		}
+		if err == nil {
+			r, err = s.apiClient.OrganizationsAPI.DeleteOrganization(*s.apiContext, probeOrg.GetId()).Execute()
+			if err != nil {
+				err = newIqApiError("Delete Organization", r, err)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("%v - delete Organization %s (%s) by hand", err, probeName, probeOrg.GetId())
+		}
+		checks.Check(fmt.Sprintf("Remove temporary Organization %s", probeName), "", err)
+	}()
+
+	probeApp, r, err = s.apiClient.ApplicationsAPI.AddApplication(*s.apiContext).ApiApplicationDTO(sonatypeiq.ApiApplicationDTO{
+		PublicId:       &probeName,
+		Name:           &probeName,
+		OrganizationId: probeOrg.Id,
+	}).Execute()
+	if err != nil {
+		probeApp = nil
+		err = newIqApiError("Add Application", r, err)
+	}
+	if !checks.Check(fmt.Sprintf("Create Applications in %s", organizationName), "", err) {
+		return
+	}
+
+	provider, token, repositoryUrl, branch := "github", "preflight", "https://github.com/sonatype/preflight", "main"
+	_, r, err = s.apiClient.SourceControlAPI.AddSourceControl(*s.apiContext, "organization", probeOrg.GetId()).ApiSourceControlDTO(sonatypeiq.ApiSourceControlDTO{
+		Provider: &provider,
+		Token:    &token,
+	}).Execute()
+	if err == nil {
+		_, r, err = s.apiClient.SourceControlAPI.AddSourceControl(*s.apiContext, "application", probeApp.GetId()).ApiSourceControlDTO(sonatypeiq.ApiSourceControlDTO{
+			RepositoryUrl: &repositoryUrl,
+			BaseBranch:    &branch,
+		}).Execute()
+	}
+	if err != nil {
+		err = newIqApiError("Add source control", r, err)
+	}
+	checks.Check(fmt.Sprintf("Manage source control configuration in %s", organizationName), "", err)
+}
@@ -0,0 +1,122 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package iq
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPermissionsTestServer(denied string, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method+" "+r.URL.Path == denied:
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/organizations":
+			fmt.Fprint(w, `{"organizations":[{"id":"ROOT_ORGANIZATION_ID","name":"Root Organization"},{"id":"org-team","name":"Team","parentOrganizationId":"ROOT_ORGANIZATION_ID"}]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/applications":
+			fmt.Fprint(w, `{"applications":[]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/roles":
+			fmt.Fprint(w, `{"roles":[{"id":"role-owner","name":"Owner"},{"id":"role-developer","name":"Developer"}]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/roleMemberships/organization/org-team":
+			fmt.Fprint(w, `{"memberMappings":[
+				{"roleId":"role-owner","members":[{"ownerId":"ROOT_ORGANIZATION_ID","ownerType":"ORGANIZATION","type":"USER","userOrGroupName":"admin"},{"ownerId":"org-team","ownerType":"ORGANIZATION","type":"GROUP","userOrGroupName":"jo"}]},
+				{"roleId":"role-developer","members":[{"ownerId":"org-team","ownerType":"ORGANIZATION","type":"USER","userOrGroupName":"jo"}]}
+			]}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/organizations":
+			fmt.Fprint(w, `{"id":"org-probe","name":"probe","parentOrganizationId":"org-team"}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/applications":
+			fmt.Fprint(w, `{"id":"app-probe","publicId":"probe","name":"probe","organizationId":"org-probe"}`)
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/v2/sourceControl/"):
+			fmt.Fprint(w, `{}`)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestValidatePermissions(t *testing.T) {
+	requests := make([]string, 0)
+	iqServer := newPermissionsTestServer("", &requests)
+	defer iqServer.Close()
+
+	checks := NewNxiqServer(iqServer.URL, "admin", "admin123").ValidatePermissions("Team", false)
+	assert.True(t, checks.Passed())
+	assert.Len(t, checks.Checks, 3)
+	assert.Equal(t, "Read roles of admin in Team", checks.Checks[2].Description)
+	assert.Equal(t, "Owner (from Root Organization)", checks.Checks[2].Detail)
+	for _, request := range requests {
+		assert.True(t, strings.HasPrefix(request, "GET "), "nothing is written: %s", request)
+	}
+
+	// Roles held only through a group, or other than Owner, cannot be checked - so do not fail
+	checks = NewNxiqServer(iqServer.URL, "jo", "jo123").ValidatePermissions("Team", false)
+	assert.True(t, checks.Passed())
+	assert.Contains(t, checks.Checks[2].Detail, "Developer - onboarding needs Owner")
+	checks = NewNxiqServer(iqServer.URL, "sam", "sam123").ValidatePermissions("Team", false)
+	assert.True(t, checks.Passed())
+	assert.Contains(t, checks.Checks[2].Detail, "no role is granted to sam directly")
+}
+
+func TestValidatePermissionsProbingWrites(t *testing.T) {
+	requests := make([]string, 0)
+	iqServer := newPermissionsTestServer("", &requests)
+	defer iqServer.Close()
+
+	checks := NewNxiqServer(iqServer.URL, "admin", "admin123").ValidatePermissions("Team", true)
+	assert.True(t, checks.Passed())
+	assert.Len(t, checks.Checks, 7)
+	assert.Contains(t, checks.Checks[6].Description, "Remove temporary Organization "+PREFLIGHT_NAME_PREFIX)
+	assert.Contains(t, requests, "DELETE /api/v2/applications/app-probe")
+	assert.Equal(t, "DELETE /api/v2/organizations/org-probe", requests[len(requests)-1])
+}
+
+func TestValidatePermissionsDenied(t *testing.T) {
+	requests := make([]string, 0)
+	iqServer := newPermissionsTestServer("GET /api/v2/roleMemberships/organization/org-team", &requests)
+	defer iqServer.Close()
+
+	checks := NewNxiqServer(iqServer.URL, "admin", "admin123").ValidatePermissions("Team", false)
+	assert.False(t, checks.Passed())
+	assert.Len(t, checks.Checks, 3)
+	assert.Contains(t, checks.Checks[2].Detail, "403")
+
+	requests = make([]string, 0)
+	iqServer = newPermissionsTestServer("POST /api/v2/applications", &requests)
+	defer iqServer.Close()
+
+	checks = NewNxiqServer(iqServer.URL, "admin", "admin123").ValidatePermissions("Team", true)
+	assert.False(t, checks.Passed())
+	assert.Len(t, checks.Checks, 6)
+	assert.Equal(t, "Create Applications in Team", checks.Checks[4].Description)
+	assert.False(t, checks.Checks[4].Passed)
+	assert.True(t, checks.Checks[5].Passed, "the temporary Organization is still removed")
+	assert.Equal(t, "DELETE /api/v2/organizations/org-probe", requests[len(requests)-1])
+
+	checks = NewNxiqServer(iqServer.URL, "admin", "admin123").ValidatePermissions("Missing", false)
+	assert.False(t, checks.Passed())
+	assert.Len(t, checks.Checks, 2)
+}
@@ -20,12 +20,16 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
+	sonatypeiq "github.com/sonatype-nexus-community/nexus-iq-api-client-go"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/config"
 	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/iq"
 	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/scm"
 	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
@@ -33,36 +37,158 @@ import (
 )
 
 const (
-	ENV_ADO_PAT       = "SCM_ADO_PAT"
-	ENV_NXIQ_USERNAME = "NXIQ_USERNAME"
-	ENV_NXIQ_PASSWORD = "NXIQ_PASSWORD"
+	ENV_ADO_PAT                   = "SCM_ADO_PAT"
+	ENV_BITBUCKET_CLOUD_TOKEN     = "SCM_BITBUCKET_CLOUD_TOKEN"
+	ENV_BITBUCKET_CLOUD_USERNAME  = "SCM_BITBUCKET_CLOUD_USERNAME"
+	ENV_BITBUCKET_SERVER_TOKEN    = "SCM_BITBUCKET_SERVER_TOKEN"
+	ENV_BITBUCKET_SERVER_USERNAME = "SCM_BITBUCKET_SERVER_USERNAME"
+	ENV_GITHUB_TOKEN              = "SCM_GITHUB_TOKEN"
+	ENV_GITLAB_TOKEN              = "SCM_GITLAB_TOKEN"
+	ENV_MANIFEST_TOKEN            = "SCM_MANIFEST_TOKEN"
+	ENV_MANIFEST_USERNAME         = "SCM_MANIFEST_USERNAME"
+	ENV_NXIQ_USERNAME             = "NXIQ_USERNAME"
+	ENV_NXIQ_PASSWORD             = "NXIQ_PASSWORD"
+)
+
+const (
+	COMMAND_APPLY    = "apply"
+	COMMAND_EXPORT   = "export"
+	COMMAND_PLAN     = "plan"
+	COMMAND_VALIDATE = "validate"
+)
+
+// Exit codes, so CI pipelines can tell the outcome of a run apart
+const (
+	EXIT_SUCCESS         = 0
+	EXIT_FATAL           = 1
+	EXIT_USAGE           = 2
+	EXIT_PARTIAL_FAILURE = 3
+	EXIT_NOTHING_TO_DO   = 4
 )
 
 var (
-	azureScm              bool   = false
-	debugLogging          bool   = false
-	currentRuntime        string = runtime.GOOS
-	commit                       = "unknown"
-	nxiqOrgNameToImportTo string
-	nxiqUrl               string
-	nxiqUsername          string
-	nxiqPassword          string
-	version               = "dev"
+	command                 string
+	configFile              string
+	continueOnError         bool = false
+	concurrency             int  = 1
+	azureScm                bool = false
+	azureCollections        []string
+	azureConcurrency        int
+	azureOrganizations      []string
+	azureIncludeDisabled    bool = false
+	azureIncludeEmpty       bool = false
+	azureIncludeForks       bool = false
+	bitbucketCloudScm       bool = false
+	bitbucketCloudWorkspace string
+	bitbucketServerScm      bool = false
+	bitbucketServerUrl      string
+	debugLogging            bool   = false
+	currentRuntime          string = runtime.GOOS
+	commit                         = "unknown"
+	githubScm               bool   = false
+	githubUrl               string
+	gitlabScm               bool = false
+	gitlabUrl               string
+	gitlabIncludeArchived   bool = false
+	manifestFile            string
+	exportFormat            string
+	exportFile              string
+	nxiqOrgNameToImportTo   string
+	nxiqUrl                 string
+	nxiqUsername            string
+	nxiqPassword            string
+	planFile                string
+	reportFile              string
+	journalFile             string
+	maxAttempts             int
+	matchBy                 string
+	publicIdStrategy        string
+	nonInteractive          bool = false
+	resume                  bool = false
+	skipPreflight           bool = false
+	probeWrites             bool = false
+	filters                 scm.Filters
+	naming                  scm.Naming
+	version                 = "dev"
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: sonatype-lifecycle-bulk-scm-onboarder [OPTIONS]\n")
+	fmt.Fprintf(os.Stderr, "usage: sonatype-lifecycle-bulk-scm-onboarder [COMMAND] [OPTIONS]\n")
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	fmt.Fprintf(os.Stderr, "  (none)  Preview the SCM structure and, once confirmed, create it in Sonatype Lifecycle\n")
+	fmt.Fprintf(os.Stderr, "  %s    Work out the changes that would be made in Sonatype Lifecycle and write them to a plan file, without making them\n", COMMAND_PLAN)
+	fmt.Fprintf(os.Stderr, "  %s   Make exactly the changes recorded in a plan file, provided Sonatype Lifecycle has not changed since it was made\n", COMMAND_APPLY)
+	fmt.Fprintf(os.Stderr, "  %s   Write the SCM structure, with the names and IDs it would be onboarded with, to a YAML, JSON or CSV file for review - without connecting to Sonatype Lifecycle\n", COMMAND_EXPORT)
+	fmt.Fprintf(os.Stderr, "  %s Check the credentials for Sonatype Lifecycle and every SCM can do everything onboarding needs, without onboarding anything\n", COMMAND_VALIDATE)
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
 	flag.PrintDefaults()
-	os.Exit(2)
+	fmt.Fprintf(os.Stderr, "\nExit codes:\n")
+	fmt.Fprintf(os.Stderr, "  %d  Applied successfully (or plan written with changes to make)\n", EXIT_SUCCESS)
+	fmt.Fprintf(os.Stderr, "  %d  Fatal error - the run stopped (or a check made by validate or pre-flight failed)\n", EXIT_FATAL)
+	fmt.Fprintf(os.Stderr, "  %d  Invalid command or options\n", EXIT_USAGE)
+	fmt.Fprintf(os.Stderr, "  %d  Partial failure - some Organizations or Applications failed under -continue-on-error\n", EXIT_PARTIAL_FAILURE)
+	fmt.Fprintf(os.Stderr, "  %d  Nothing to do\n", EXIT_NOTHING_TO_DO)
+	os.Exit(EXIT_USAGE)
 }
 
 func init() {
 	flag.BoolVar(&azureScm, "azure", false, fmt.Sprintf("Load from Azure DevOps (set PAT in %s Environment Variable else you'll be prompted to enter it)", ENV_ADO_PAT))
+	flag.Func("azure-collection", "URL of an Azure DevOps Server (on-premises) Project Collection to load, e.g. https://tfs.example.com/tfs/DefaultCollection (may be repeated)", func(value string) error {
+		azureCollections = append(azureCollections, value)
+		return nil
+	})
+	flag.IntVar(&azureConcurrency, "azure-concurrency", scm.DEFAULT_ADO_CONCURRENCY, "Number of requests made to Azure DevOps in parallel while loading Organizations, Projects and Repositories")
+	flag.Func("azure-organization", "Name or URL of an Azure DevOps Organization to load, e.g. acme or https://dev.azure.com/acme - instead of every Organization the PAT can see (may be repeated)", func(value string) error {
+		azureOrganizations = append(azureOrganizations, value)
+		return nil
+	})
+	flag.BoolVar(&azureIncludeDisabled, "azure-include-disabled", false, "Include disabled Azure DevOps Repositories")
+	flag.BoolVar(&azureIncludeEmpty, "azure-include-empty", false, "Include empty Azure DevOps Repositories (those with no Default Branch)")
+	flag.BoolVar(&azureIncludeForks, "azure-include-forks", false, "Include forked Azure DevOps Repositories")
+	flag.BoolVar(&bitbucketCloudScm, "bitbucket-cloud", false, fmt.Sprintf("Load from Bitbucket Cloud (set App Password in %s and username in %s, or a Workspace Access Token in %s, else you'll be prompted to enter it)", ENV_BITBUCKET_CLOUD_TOKEN, ENV_BITBUCKET_CLOUD_USERNAME, ENV_BITBUCKET_CLOUD_TOKEN))
+	flag.StringVar(&bitbucketCloudWorkspace, "bitbucket-cloud-workspace", "", "Only load this Bitbucket Cloud Workspace (required when using a Workspace Access Token)")
+	flag.BoolVar(&bitbucketServerScm, "bitbucket-server", false, fmt.Sprintf("Load from Bitbucket Server / Data Center (set HTTP access token in %s Environment Variable else you'll be prompted to enter it)", ENV_BITBUCKET_SERVER_TOKEN))
+	flag.StringVar(&bitbucketServerUrl, "bitbucket-server-url", "", "URL of your Bitbucket Server / Data Center (e.g. https://bitbucket.example.com)")
+	flag.StringVar(&configFile, "config", "", "Path of a YAML or JSON configuration file holding settings for the run - options given on the command line take precedence")
+	flag.BoolVar(&continueOnError, "continue-on-error", false, "Record Organizations and Applications that fail and carry on with the rest, rather than stopping at the first failure")
+	flag.IntVar(&concurrency, "concurrency", 1, "Number of Applications to create and configure in Sonatype Lifecycle in parallel")
+	flag.StringVar(&exportFormat, "format", scm.INVENTORY_FORMAT_YAML, fmt.Sprintf("Format of the file the export command writes: %s", strings.Join(scm.INVENTORY_FORMATS, ", ")))
+	flag.BoolVar(&githubScm, "github", false, fmt.Sprintf("Load from GitHub or GitHub Enterprise Server (set token in %s Environment Variable else you'll be prompted to enter it)", ENV_GITHUB_TOKEN))
+	flag.StringVar(&githubUrl, "github-url", "", fmt.Sprintf("API URL for GitHub Enterprise Server (e.g. https://github.example.com/api/v3) - defaults to %s", scm.DEFAULT_GITHUB_BASE_URL))
+	flag.BoolVar(&gitlabScm, "gitlab", false, fmt.Sprintf("Load from GitLab (set token in %s Environment Variable else you'll be prompted to enter it)", ENV_GITLAB_TOKEN))
+	flag.StringVar(&gitlabUrl, "gitlab-url", "", fmt.Sprintf("API URL for self-managed GitLab (e.g. https://gitlab.example.com/api/v4) - defaults to %s", scm.DEFAULT_GITLAB_BASE_URL))
+	flag.BoolVar(&gitlabIncludeArchived, "gitlab-include-archived", false, "Include archived GitLab Projects")
+	flag.StringVar(&manifestFile, "manifest", "", fmt.Sprintf("Load from a manifest - a YAML, JSON or CSV file listing Organizations and Repositories, such as one written by the export command (set a token in %s to apply SCM configuration)", ENV_MANIFEST_TOKEN))
 	flag.StringVar(&nxiqUrl, "url", "http://localhost:8070", "URL including protocol to your Sonatype Lifecycle")
 	flag.StringVar(&nxiqUsername, "username", "", fmt.Sprintf("Username used to authenticate to Sonatype Lifecycle (can also be set using the environment variable %s, else you'll be prompted to enter it)", ENV_NXIQ_USERNAME))
 	flag.StringVar(&nxiqPassword, "password", "", fmt.Sprintf("Password used to authenticate to Sonatype Lifecycle (can also be set using the environment variable %s, else you'll be prompted to enter it)", ENV_NXIQ_PASSWORD))
+	flag.IntVar(&maxAttempts, "max-attempts", util.DEFAULT_RETRY_MAX_ATTEMPTS, "Maximum number of attempts for each request to Sonatype Lifecycle or your SCM that fails with a rate limit, server or network error")
+	flag.StringVar(&matchBy, "match-by", iq.APPLICATION_MATCH_BY_NAME, fmt.Sprintf("How existing Applications are recognized: %s (same name in the same Organization) or %s (same Repository URL, wherever the Application lives)", iq.APPLICATION_MATCH_BY_NAME, iq.APPLICATION_MATCH_BY_REPOSITORY_URL))
+	flag.StringVar(&naming.OrganizationName, "organization-name-template", "", "Template for the names Organizations are created with, e.g. '{{.Account}} {{.Organization}}' (fields: Account, Project, Organization, Provider)")
+	flag.StringVar(&naming.ApplicationName, "application-name-template", "", "Template for the names Applications are created with, e.g. '{{.Project}} {{.Repo}}' (fields: Account, Project, Organization, Repo, RepositoryUrl, Provider)")
+	flag.StringVar(&naming.ApplicationId, "application-id-template", "", "Template for the IDs Applications are created with, e.g. 'ado-{{.Project | safeId}}-{{.Repo | safeId}}' (fields as -application-name-template)")
+	flag.BoolVar(&probeWrites, "probe-writes", false, "With the validate command, also prove the Sonatype Lifecycle user can onboard by creating (then deleting) a temporary Organization and Application in each target Organization")
+	flag.StringVar(&publicIdStrategy, "public-id-strategy", iq.PUBLIC_ID_STRATEGY_NAME, fmt.Sprintf("How the IDs of new Applications are chosen: %s (from the Repository name, suffixed -1, -2... on a collision) or %s (derived from the Repository's identity in the SCM, so the same on every run)", iq.PUBLIC_ID_STRATEGY_NAME, iq.PUBLIC_ID_STRATEGY_SCM_IDENTITY))
 	flag.StringVar(&nxiqOrgNameToImportTo, "org-name", "Root Organization", "Name of Organization to import structure into")
+	flag.StringVar(&exportFile, "output", "", "Path of the file the export command writes (default inventory.<format>)")
+	flag.StringVar(&planFile, "plan", "plan.json", "Path of the plan file to write (plan command) or read (apply command)")
+	flag.Func("include", "Only onboard Repositories matching this filter rule, e.g. project=team-*,repository=re:api-.* (may be repeated)", func(value string) error {
+		rule, err := parseFilterRule(value)
+		filters.Include = append(filters.Include, rule)
+		return err
+	})
+	flag.Func("exclude", "Never onboard Repositories matching this filter rule, e.g. repository=*-archive (may be repeated)", func(value string) error {
+		rule, err := parseFilterRule(value)
+		filters.Exclude = append(filters.Exclude, rule)
+		return err
+	})
+	flag.StringVar(&journalFile, "journal", "journal.jsonl", "Path of the journal recording every Organization and Application created or updated")
+	flag.StringVar(&reportFile, "report", "report.json", "Path of the summary of successes and failures written at the end of a run")
+	flag.BoolVar(&nonInteractive, "non-interactive", false, "Never prompt: fail if a credential is not supplied by argument or Environment Variable, and proceed without asking for confirmation")
+	flag.BoolVar(&resume, "resume", false, "Resume a previous run that failed part way through, skipping everything its journal shows was completed")
+	flag.BoolVar(&skipPreflight, "skip-preflight", false, "Do not check credentials and permissions before creating anything in Sonatype Lifecycle")
 	flag.BoolVar(&debugLogging, "X", false, "Enable debug logging")
+	flag.BoolVar(&nonInteractive, "yes", false, "Alias for -non-interactive")
 }
 
 func main() {
@@ -71,24 +197,53 @@ func main() {
 	log.SetFormatter(&util.LogFormatter{Module: "SLI"})
 
 	flag.Usage = usage
-	flag.Parse()
+	parseCommandAndFlags()
 
 	// Disable Debug Logging if not requested
 	if !debugLogging {
 		log.SetLevel(log.InfoLevel)
 	}
 
-	// Load Credentials
-	err := loadCredentials()
+	sources, err := loadConfiguration()
 	if err != nil {
-		os.Exit(1)
+		println(fmt.Sprintf("Error: %v", err))
+		os.Exit(EXIT_USAGE)
+	}
+
+	if command == COMMAND_EXPORT && !slices.Contains(scm.INVENTORY_FORMATS, exportFormat) {
+		println(fmt.Sprintf("-format must be one of %s", strings.Join(scm.INVENTORY_FORMATS, ", ")))
+		os.Exit(EXIT_USAGE)
+	}
+	if probeWrites && command != COMMAND_VALIDATE {
+		println(fmt.Sprintf("-probe-writes only applies to the %s command", COMMAND_VALIDATE))
+		os.Exit(EXIT_USAGE)
+	}
+
+	// Load Credentials - export never connects to Sonatype Lifecycle
+	if command != COMMAND_EXPORT {
+		err = loadCredentials()
+		if err != nil {
+			println(fmt.Sprintf("Error: %v", err))
+			os.Exit(EXIT_FATAL)
+		}
 	}
 
 	if strings.TrimSpace(nxiqUrl) == "" {
 		println("URL to Sonatype Lifecycle must be supplied")
-		os.Exit(1)
+		os.Exit(EXIT_USAGE)
+	}
+
+	if concurrency < 1 {
+		println("-concurrency must be at least 1")
+		os.Exit(EXIT_USAGE)
 	}
 
+	if maxAttempts < 1 {
+		println("-max-attempts must be at least 1")
+		os.Exit(EXIT_USAGE)
+	}
+	util.DefaultRetryPolicy.MaxAttempts = maxAttempts
+
 	// Output Banner
 	println(strings.Repeat("⬢⬡", 42))
 	println("")
@@ -105,56 +260,240 @@ func main() {
 	println(strings.Repeat("⬢⬡", 42))
 	println("")
 
+	if command == COMMAND_EXPORT {
+		exportSources(sources)
+		return
+	}
+
 	// Connect to IQ and load cache
 	nxiqServer := iq.NewNxiqServer(nxiqUrl, nxiqUsername, nxiqPassword)
+	nxiqServer.SetConcurrency(concurrency)
+	nxiqServer.SetContinueOnError(continueOnError)
+	if command != COMMAND_APPLY {
+		// A plan records the strategy it was made with
+		err = nxiqServer.SetApplicationMatchStrategy(matchBy)
+		if err == nil {
+			err = nxiqServer.SetPublicIdStrategy(publicIdStrategy)
+		}
+		if err != nil {
+			println(fmt.Sprintf("Error: %v", err))
+			os.Exit(EXIT_USAGE)
+		}
+	}
+	if command != COMMAND_APPLY {
+		// Obtained once, for both the checks and loading - applying obtains only those its plan needs
+		err = resolveSourceTokens(sources)
+		if err != nil {
+			println(fmt.Sprintf("Error: %v", err))
+			os.Exit(EXIT_FATAL)
+		}
+	}
+	if command == COMMAND_VALIDATE {
+		if !runChecks(nxiqServer, targetOrganizationNames(sources), sources, probeWrites) {
+			os.Exit(EXIT_FATAL)
+		}
+		println("All checks passed 😉")
+		return
+	}
 	err = nxiqServer.InitCache()
 	if err != nil {
 		println(fmt.Sprintf("Error: %v", err))
-		os.Exit(1)
+		os.Exit(EXIT_FATAL)
 	}
-	iqTargetOrganization := nxiqServer.ValidateOrganizationByName(nxiqOrgNameToImportTo)
-	if iqTargetOrganization == nil {
-		println(fmt.Sprintf("Could not find requested Organization %s", nxiqOrgNameToImportTo))
-		os.Exit(1)
+	if command == COMMAND_APPLY {
+		applyPlanFile(nxiqServer, sources)
+		return
 	}
 
-	println(fmt.Sprintf("Target Organization in Sonatype: %s (%s)", *iqTargetOrganization.Name, *iqTargetOrganization.Id))
-	println("")
+	if len(sources) == 0 {
+		// Nothing to load from - just check the target Organization exists
+		findTargetOrganization(nxiqServer, nxiqOrgNameToImportTo)
+		return
+	}
 
-	var orgContents *scm.OrgContents
-	var scmConfig *scm.ScmConfiguration
+	// Check every target Organization exists before loading anything
+	targetOrganizations := make([]*sonatypeiq.ApiOrganizationDTO, len(sources))
+	for i, source := range sources {
+		targetOrganizations[i] = findTargetOrganization(nxiqServer, source.Organization)
+	}
+	println("")
+	if command != COMMAND_PLAN {
+		preflight(nxiqServer, targetOrganizationNames(sources), sources)
+	}
 
-	// If Azure, query Azure DevOps
-	if azureScm {
-		println("Loading from Azure DevOps...")
-		println("")
-		orgContents, scmConfig, err = loadFromAzureDevOps()
+	importSources := make([]iq.ImportSource, 0, len(sources))
+	organizationCount := 0
+	for i, source := range sources {
+		println(fmt.Sprintf("Loading from %s...", source.DisplayName()))
+		orgContents, scmConfig, err := loadFromSource(source)
 		if err != nil {
-			panic(err)
+			println(fmt.Sprintf("Error: Failed to load from %s: %v", source.DisplayName(), err))
+			os.Exit(EXIT_FATAL)
 		}
+		organizationCount += len(orgContents.Organizations)
+		importSources = append(importSources, iq.ImportSource{
+			Name:             source.DisplayName(),
+			OrgContents:      *orgContents,
+			RootOrganization: targetOrganizations[i],
+			ScmConfig:        scmConfig,
+		})
 	}
+	println("")
 
-	if orgContents != nil {
-		orgContents.PrintTree()
+	if organizationCount == 0 {
+		println("Nothing to do - no Organizations or Repositories to onboard were found")
+		os.Exit(EXIT_NOTHING_TO_DO)
+	}
+
+	if command == COMMAND_PLAN {
+		plan, err := nxiqServer.PlanSources(importSources)
+		if err != nil {
+			println(fmt.Sprintf("Error: %v", err))
+			os.Exit(EXIT_FATAL)
+		}
+		plan.Print()
+
+		err = plan.Save(planFile)
+		if err != nil {
+			println(fmt.Sprintf("Error: Failed to write plan to %s: %v", planFile, err))
+			os.Exit(EXIT_FATAL)
+		}
+		println(fmt.Sprintf("Plan written to %s", planFile))
+		if !plan.HasChanges() {
+			println("Nothing to do - Sonatype Lifecycle already matches your SCM")
+			os.Exit(EXIT_NOTHING_TO_DO)
+		}
+	} else {
+		printSources(importSources)
 
-		println("")
 		continueToCreateInIq := askForConfirmation("Continue to create Organizations and Applications in Sonatype Lifecycle?")
 		if continueToCreateInIq {
+			openJournal(nxiqServer)
+
 			println("Creating Organizations and Applications in Sonatype Lifecycle. Please wait...")
-			err = nxiqServer.ApplyOrgContents(*orgContents, iqTargetOrganization, scmConfig)
-			if err != nil {
-				println("❌ Sorry - something went awry: ", err)
-			}
-			println("Done 😉")
+			err = nxiqServer.ApplySources(importSources)
+			printMovedApplications(nxiqServer)
+			finishApply(nxiqServer, err)
 		}
 	}
 }
 
+// findTargetOrganization looks up the Organization to import into, exiting if it does not exist.
+func findTargetOrganization(nxiqServer *iq.NxiqServer, name string) *sonatypeiq.ApiOrganizationDTO {
+	iqTargetOrganization := nxiqServer.ValidateOrganizationByName(name)
+	if iqTargetOrganization == nil {
+		println(fmt.Sprintf("Could not find requested Organization %s", name))
+		os.Exit(EXIT_FATAL)
+	}
+
+	println(fmt.Sprintf("Target Organization in Sonatype: %s (%s)", *iqTargetOrganization.Name, *iqTargetOrganization.Id))
+	return iqTargetOrganization
+}
+
+// printSources previews what each source will create, and where.
+func printSources(importSources []iq.ImportSource) {
+	for _, source := range importSources {
+		organizations, applications := source.OrgContents.Count()
+		println(fmt.Sprintf("%s into %s - %d Organizations and %d Applications:", source.Name, source.RootOrganization.GetName(), organizations, applications))
+		source.OrgContents.PrintTree()
+		println("")
+	}
+}
+
+// exportSources loads every source and writes what was found to the -output file in the -format requested.
+func exportSources(sources []config.Source) {
+	if len(sources) == 0 {
+		println("Nothing to export - select a source (e.g. -azure) or give sources in a -config file")
+		os.Exit(EXIT_USAGE)
+	}
+
+	exported := scm.OrgContents{Organizations: make([]scm.Organization, 0)}
+	for _, source := range sources {
+		println(fmt.Sprintf("Loading from %s...", source.DisplayName()))
+		orgContents, _, err := loadFromSource(source)
+		if err != nil {
+			println(fmt.Sprintf("Error: Failed to load from %s: %v", source.DisplayName(), err))
+			os.Exit(EXIT_FATAL)
+		}
+		exported.Organizations = append(exported.Organizations, orgContents.Organizations...)
+	}
+	println("")
+
+	if exportFile == "" {
+		exportFile = fmt.Sprintf("inventory.%s", exportFormat)
+	}
+	f, err := os.Create(exportFile)
+	if err != nil {
+		println(fmt.Sprintf("Error: Failed to write export to %s: %v", exportFile, err))
+		os.Exit(EXIT_FATAL)
+	}
+	err = exported.Export(f, exportFormat)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		println(fmt.Sprintf("Error: Failed to write export to %s: %v", exportFile, err))
+		os.Exit(EXIT_FATAL)
+	}
+
+	organizations, applications := exported.Count()
+	println(fmt.Sprintf("%d Organizations and %d Applications written to %s", organizations, applications, exportFile))
+	if organizations == 0 {
+		os.Exit(EXIT_NOTHING_TO_DO)
+	}
+}
+
+// printMovedApplications reports existing Applications that were updated outside of the Organization the SCM places them in.
+func printMovedApplications(nxiqServer *iq.NxiqServer) {
+	moved := nxiqServer.MovedApplications()
+	if len(moved) == 0 {
+		return
+	}
+
+	println("")
+	println(fmt.Sprintf("⚠️  %d existing Applications were matched by Repository URL in a different Organization and were updated there rather than duplicated:", len(moved)))
+	for _, m := range moved {
+		println(fmt.Sprintf("  - %s (%s) for %s is in Organization %s (%s), not %s", m.Name, m.PublicId, m.RepositoryUrl, m.OrganizationName, m.OrganizationId, m.ExpectedOrganizationId))
+	}
+	println("")
+}
+
+// parseCommandAndFlags accepts an optional command either before or after the flags.
+func parseCommandAndFlags() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		return
+	}
+
+	command = flag.Arg(0)
+	if command != COMMAND_PLAN && command != COMMAND_APPLY && command != COMMAND_EXPORT && command != COMMAND_VALIDATE {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		usage()
+	}
+
+	_ = flag.CommandLine.Parse(flag.Args()[1:])
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %s\n", strings.Join(flag.Args(), " "))
+		usage()
+	}
+}
+
+// askForConfirmation asks the user a yes/no question - always answered yes with -non-interactive.
 func askForConfirmation(s string) bool {
+	if nonInteractive {
+		println(fmt.Sprintf("%s [y/n]: y (-non-interactive)", s))
+		return true
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Printf("%s [y/n]: ", s)
 		response, err := reader.ReadString('\n')
+		if err == io.EOF {
+			println("")
+			println("Error: no answer could be read as standard input is closed - use -non-interactive to proceed without confirmation")
+			os.Exit(EXIT_FATAL)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -167,11 +506,24 @@ func askForConfirmation(s string) bool {
 	}
 }
 
-func secretPrompt(label string) string {
+// secretPrompt reads a value from the terminal without echoing it. It fails rather than prompting with
+// -non-interactive, or when standard input is not a terminal (e.g. in a CI pipeline).
+func secretPrompt(label string) (string, error) {
+	if nonInteractive {
+		return "", fmt.Errorf("cannot prompt for %s with -non-interactive", label)
+	}
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return "", fmt.Errorf("cannot prompt for %s as standard input is not a terminal", label)
+	}
+
 	var s string
 	for {
-		fmt.Fprint(os.Stderr, label+" ")
-		b, _ := term.ReadPassword(int(syscall.Stdin))
+		fmt.Fprintf(os.Stderr, "Enter your %s: ", label)
+		b, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return "", fmt.Errorf("failed to read %s: %v", label, err)
+		}
 		s = string(b)
 		if s != "" {
 			break
@@ -179,23 +531,339 @@ func secretPrompt(label string) string {
 	}
 	fmt.Println()
 	log.Debug(fmt.Sprintf("Read '%s' from STDIN: ", s))
-	return s
+	return s, nil
+}
+
+func applyPlanFile(nxiqServer *iq.NxiqServer, sources []config.Source) {
+	plan, err := iq.LoadPlan(planFile)
+	if err != nil {
+		println(fmt.Sprintf("Error: %v", err))
+		os.Exit(EXIT_FATAL)
+	}
+
+	if resume {
+		// The journal must be loaded before checking for drift, so that changes made by the run
+		// being resumed are not mistaken for drift
+		journal, err := iq.OpenJournal(journalFile, true)
+		if err != nil {
+			println(fmt.Sprintf("Error: %v", err))
+			os.Exit(EXIT_FATAL)
+		}
+		nxiqServer.SetJournal(journal)
+	}
+
+	providers := plan.ScmProvidersRequiringConfiguration()
+	planSources := make([]config.Source, 0, len(providers))
+	for _, provider := range providers {
+		source, err := sourceForProvider(provider, sources)
+		if err == nil && source.Type == "" {
+			err = fmt.Errorf("Unsupported SCM provider in plan: %s", provider)
+		}
+		if err == nil {
+			source.Token, err = sourceToken(source)
+		}
+		if err != nil {
+			println(fmt.Sprintf("Error: %v", err))
+			os.Exit(EXIT_FATAL)
+		}
+		planSources = append(planSources, source)
+	}
+	preflight(nxiqServer, nxiqServer.TargetOrganizationNames(plan), planSources)
+
+	drift, err := nxiqServer.ValidatePlan(plan)
+	if err != nil {
+		println(fmt.Sprintf("Error: %v", err))
+		os.Exit(EXIT_FATAL)
+	}
+	if len(drift) > 0 {
+		println(fmt.Sprintf("❌ Sonatype Lifecycle has changed since %s was made - refusing to apply it:", planFile))
+		for _, d := range drift {
+			println(fmt.Sprintf("  - %s", d))
+		}
+		println("Create a new plan and have it reviewed again.")
+		os.Exit(EXIT_FATAL)
+	}
+
+	scmConfigs := make(map[string]*scm.ScmConfiguration)
+	for i, provider := range providers {
+		scmConfigs[provider] = &scm.ScmConfiguration{Type: provider, Password: planSources[i].Token}
+	}
+
+	plan.Print()
+	println("")
+	if !plan.HasChanges() {
+		println(fmt.Sprintf("Nothing to do - %s makes no changes", planFile))
+		os.Exit(EXIT_NOTHING_TO_DO)
+	}
+	if askForConfirmation(fmt.Sprintf("Apply %s to Sonatype Lifecycle?", planFile)) {
+		openJournal(nxiqServer)
+
+		println("Applying plan to Sonatype Lifecycle. Please wait...")
+		err = nxiqServer.ApplyPlan(plan, scmConfigs)
+		finishApply(nxiqServer, err)
+	}
+}
+
+/**
+ * Prints and writes the summary of an apply run, then exits with EXIT_FATAL if it stopped early (`err`),
+ * EXIT_PARTIAL_FAILURE if anything failed under -continue-on-error or EXIT_NOTHING_TO_DO if nothing needed doing.
+ */
+func finishApply(nxiqServer *iq.NxiqServer, err error) {
+	nxiqServer.Journal().Close()
+
+	report := nxiqServer.Report()
+	report.Print()
+	saveErr := report.Save(reportFile)
+	if saveErr != nil {
+		println(fmt.Sprintf("Error: Failed to write summary to %s: %v", reportFile, saveErr))
+	} else {
+		println(fmt.Sprintf("Summary written to %s", reportFile))
+	}
+	println("")
+
+	if err != nil {
+		println("❌ Sorry - something went awry: ", err.Error())
+		println(fmt.Sprintf("Progress has been recorded in %s - re-run with -resume to continue from where this run stopped", journalFile))
+		os.Exit(EXIT_FATAL)
+	}
+	if report.HasFailures() {
+		println(fmt.Sprintf("❌ Some Organizations or Applications could not be created or updated - re-run with -resume to retry just those (progress is recorded in %s)", journalFile))
+		os.Exit(EXIT_PARTIAL_FAILURE)
+	}
+	if report.IsEmpty() {
+		println("Nothing to do 😉")
+		os.Exit(EXIT_NOTHING_TO_DO)
+	}
+	println("Done 😉")
+}
+
+// openJournal opens the journal for this run (if not already open) and attaches it to the server.
+func openJournal(nxiqServer *iq.NxiqServer) *iq.Journal {
+	if journal := nxiqServer.Journal(); journal != nil {
+		return journal
+	}
+
+	journal, err := iq.OpenJournal(journalFile, resume)
+	if err != nil {
+		println(fmt.Sprintf("Error: %v", err))
+		os.Exit(EXIT_FATAL)
+	}
+	nxiqServer.SetJournal(journal)
+	return journal
+}
+
+// sourceForProvider is the first source of the given SCM provider, else a source of that provider's only type.
+// Bitbucket Cloud and Bitbucket Server are the same SCM provider but need different credentials, so which one
+// is meant must come from the sources.
+func sourceForProvider(provider string, sources []config.Source) (config.Source, error) {
+	matching := make([]config.Source, 0)
+	for _, source := range sources {
+		if source.ScmProvider() == provider {
+			matching = append(matching, source)
+		}
+	}
+	for _, source := range matching {
+		if source.Type != matching[0].Type {
+			return config.Source{}, fmt.Errorf("Cannot tell which credentials to use for SCM provider %s - both %s and %s sources are configured", provider, matching[0].Type, source.Type)
+		}
+	}
+	if len(matching) > 0 {
+		return matching[0], nil
+	}
+
+	switch provider {
+	case scm.SCM_TYPE_AZURE:
+		return config.Source{Type: config.SOURCE_TYPE_AZURE}, nil
+	case scm.SCM_TYPE_BITBUCKET:
+		return config.Source{}, fmt.Errorf("Cannot tell whether SCM provider %s is Bitbucket Cloud or Bitbucket Server - pass -bitbucket-cloud or -bitbucket-server (or configure the source) as when the plan was made", provider)
+	case scm.SCM_TYPE_GITHUB:
+		return config.Source{Type: config.SOURCE_TYPE_GITHUB}, nil
+	case scm.SCM_TYPE_GITLAB:
+		return config.Source{Type: config.SOURCE_TYPE_GITLAB}, nil
+	}
+	return config.Source{}, nil
+}
+
+// resolveSourceTokens fills in the credential of every source that needs one, so that each is only prompted for
+// once. Manifest sources are left alone, as their token is optional.
+func resolveSourceTokens(sources []config.Source) error {
+	for i := range sources {
+		if sources[i].Type == config.SOURCE_TYPE_MANIFEST {
+			continue
+		}
+		token, err := sourceToken(sources[i])
+		if err != nil {
+			return fmt.Errorf("%s: %v", sources[i].DisplayName(), err)
+		}
+		sources[i].Token = token
+	}
+	return nil
 }
 
-func loadFromAzureDevOps() (*scm.OrgContents, *scm.ScmConfiguration, error) {
-	envPat := os.Getenv(ENV_ADO_PAT)
-	if strings.TrimSpace(envPat) == "" {
-		envPat = secretPrompt("Enter your Azure DevOps PAT: ")
-		log.Debug("Read Azure DevOps PAT from STDIN")
+// sourceToken is the credential for a source - from its configuration, else the Environment Variable for its
+// type, else prompted for.
+func sourceToken(source config.Source) (string, error) {
+	if strings.TrimSpace(source.Token) != "" {
+		return source.Token, nil
+	}
+
+	switch source.Type {
+	case config.SOURCE_TYPE_AZURE:
+		return secretFromEnvOrPrompt(ENV_ADO_PAT, "Azure DevOps PAT")
+	case config.SOURCE_TYPE_BITBUCKET_CLOUD:
+		return secretFromEnvOrPrompt(ENV_BITBUCKET_CLOUD_TOKEN, "Bitbucket Cloud App Password or Access Token")
+	case config.SOURCE_TYPE_BITBUCKET_SERVER:
+		return secretFromEnvOrPrompt(ENV_BITBUCKET_SERVER_TOKEN, "Bitbucket Server HTTP Access Token")
+	case config.SOURCE_TYPE_GITHUB:
+		return secretFromEnvOrPrompt(ENV_GITHUB_TOKEN, "GitHub Token")
+	case config.SOURCE_TYPE_GITLAB:
+		return secretFromEnvOrPrompt(ENV_GITLAB_TOKEN, "GitLab Token")
+	}
+	return "", fmt.Errorf("Unsupported source type: %s", source.Type)
+}
+
+// sourceUsername is the username for a source - from its configuration, else the given Environment Variable.
+func sourceUsername(source config.Source, envVar string) string {
+	if strings.TrimSpace(source.Username) != "" {
+		return source.Username
+	}
+	return os.Getenv(envVar)
+}
+
+func secretFromEnvOrPrompt(envVar string, label string) (string, error) {
+	secret := os.Getenv(envVar)
+	if strings.TrimSpace(secret) == "" {
+		var err error
+		secret, err = secretPrompt(label)
+		if err != nil {
+			return "", fmt.Errorf("no %s supplied - set the %s Environment Variable (%v)", label, envVar, err)
+		}
+		log.Debug(fmt.Sprintf("Read %s from STDIN", label))
+	}
+	return secret, nil
+}
+
+func loadFromSource(source config.Source) (*scm.OrgContents, *scm.ScmConfiguration, error) {
+	scmConnection, err := newScmIntegration(source)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	scmConnection := scm.NewAzureDevOpsScmIntegration(envPat, nil)
 	orgContents, err := scmConnection.GetMappedAsOrgContents()
 	if err != nil {
 		return nil, nil, err
 	}
+	orgContents, err = orgContents.ApplyNaming(source.Naming)
+	if err != nil {
+		return nil, nil, err
+	}
+	if publicIdStrategy == iq.PUBLIC_ID_STRATEGY_SCM_IDENTITY {
+		orgContents = orgContents.UseStableIds()
+	}
+
+	scmConfig := scmConnection.GetScmConfig()
+	if !source.AppliesScmConfiguration() {
+		scmConfig = nil
+	}
+	return orgContents, scmConfig, nil
+}
+
+// newScmIntegration connects to the SCM a source describes, obtaining its credentials.
+func newScmIntegration(source config.Source) (scm.SCMIntegration, error) {
+	if source.Type == config.SOURCE_TYPE_MANIFEST {
+		return newManifestIntegration(source), nil
+	}
+
+	token, err := sourceToken(source)
+	if err != nil {
+		return nil, err
+	}
 
-	return orgContents, scmConnection.GetScmConfig(), nil
+	switch source.Type {
+	case config.SOURCE_TYPE_AZURE:
+		return newAzureDevOpsIntegration(source, token), nil
+	case config.SOURCE_TYPE_BITBUCKET_CLOUD:
+		return newBitbucketCloudIntegration(source, token)
+	case config.SOURCE_TYPE_BITBUCKET_SERVER:
+		return newBitbucketServerIntegration(source, token)
+	case config.SOURCE_TYPE_GITHUB:
+		return newGitHubIntegration(source, token), nil
+	case config.SOURCE_TYPE_GITLAB:
+		return newGitLabIntegration(source, token), nil
+	}
+	return nil, fmt.Errorf("Unsupported source type: %s", source.Type)
+}
+
+func newAzureDevOpsIntegration(source config.Source, token string) scm.SCMIntegration {
+	scmConnection := scm.NewAzureDevOpsScmIntegration(token, nil)
+	scmConnection.Collections = source.Collections
+	scmConnection.Concurrency = source.Concurrency
+	scmConnection.Organizations = source.AzureOrganizations
+	scmConnection.IncludeDisabled = source.IncludeDisabled
+	scmConnection.IncludeEmpty = source.IncludeEmpty
+	scmConnection.IncludeForks = source.IncludeForks
+	scmConnection.Filters = source.Filters
+	return scmConnection
+}
+
+func newBitbucketCloudIntegration(source config.Source, token string) (scm.SCMIntegration, error) {
+	username := sourceUsername(source, ENV_BITBUCKET_CLOUD_USERNAME)
+	if strings.TrimSpace(username) == "" && strings.TrimSpace(source.Workspace) == "" {
+		return nil, fmt.Errorf("-bitbucket-cloud-workspace must be supplied when authenticating with an Access Token")
+	}
+
+	scmConnection := scm.NewBitbucketCloudScmIntegration(username, token, nil)
+	scmConnection.Workspace = source.Workspace
+	scmConnection.Filters = source.Filters
+	return scmConnection, nil
+}
+
+func newBitbucketServerIntegration(source config.Source, token string) (scm.SCMIntegration, error) {
+	if strings.TrimSpace(source.Url) == "" {
+		return nil, fmt.Errorf("-bitbucket-server-url must be supplied to load from Bitbucket Server")
+	}
+
+	scmConnection := scm.NewBitbucketServerScmIntegration(token, source.Url)
+	scmConnection.Username = sourceUsername(source, ENV_BITBUCKET_SERVER_USERNAME)
+	scmConnection.Filters = source.Filters
+	return scmConnection, nil
+}
+
+func newGitHubIntegration(source config.Source, token string) scm.SCMIntegration {
+	var baseUrl *string
+	if strings.TrimSpace(source.Url) != "" {
+		baseUrl = &source.Url
+	}
+
+	scmConnection := scm.NewGitHubScmIntegration(token, baseUrl)
+	scmConnection.Filters = source.Filters
+	return scmConnection
+}
+
+func newGitLabIntegration(source config.Source, token string) scm.SCMIntegration {
+	var baseUrl *string
+	if strings.TrimSpace(source.Url) != "" {
+		baseUrl = &source.Url
+	}
+
+	scmConnection := scm.NewGitLabScmIntegration(token, baseUrl)
+	scmConnection.IncludeArchived = source.IncludeArchived
+	scmConnection.Filters = source.Filters
+	return scmConnection
+}
+
+// newManifestIntegration reads a manifest source. Its token is optional - without one no SCM configuration is set, so
+// it is never prompted for.
+func newManifestIntegration(source config.Source) scm.SCMIntegration {
+	token := source.Token
+	if strings.TrimSpace(token) == "" {
+		token = os.Getenv(ENV_MANIFEST_TOKEN)
+	}
+
+	scmConnection := scm.NewManifestScmIntegration(source.Path, token)
+	scmConnection.Username = sourceUsername(source, ENV_MANIFEST_USERNAME)
+	scmConnection.Filters = source.Filters
+	return scmConnection
 }
 
 func loadCredentials() error {
@@ -203,7 +871,11 @@ func loadCredentials() error {
 		log.Debug("Username not supplied as argument - checking environment variable")
 		envUsername := os.Getenv(ENV_NXIQ_USERNAME)
 		if strings.TrimSpace(envUsername) == "" {
-			nxiqUsername = secretPrompt("Enter your Sonatype IQ Server Username: ")
+			username, err := secretPrompt("Sonatype IQ Server Username")
+			if err != nil {
+				return fmt.Errorf("no username supplied - use -username or set the %s Environment Variable (%v)", ENV_NXIQ_USERNAME, err)
+			}
+			nxiqUsername = username
 			log.Debug("Read Sonatype IQ Server Username from STDIN")
 		} else {
 			nxiqUsername = envUsername
@@ -218,7 +890,11 @@ func loadCredentials() error {
 		log.Debug("Password not supplied as argument - checking environment variable")
 		envPassword := os.Getenv(ENV_NXIQ_PASSWORD)
 		if strings.TrimSpace(envPassword) == "" {
-			nxiqPassword = secretPrompt("Enter your Sonatype IQ Server Password: ")
+			password, err := secretPrompt("Sonatype IQ Server Password")
+			if err != nil {
+				return fmt.Errorf("no password supplied - use -password or set the %s Environment Variable (%v)", ENV_NXIQ_PASSWORD, err)
+			}
+			nxiqPassword = password
 			log.Debug("Read Sonatype IQ Server Password from STDIN")
 		} else {
 			nxiqPassword = envPassword
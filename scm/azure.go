@@ -19,6 +19,9 @@ package scm
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -30,10 +33,13 @@ import (
 	"github.com/microsoft/azure-devops-go-api/azuredevops/v7/core"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/v7/git"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/v7/profile"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
 )
 
 const (
-	DEFAULT_ADO_BASE_URL = "https://app.vssps.visualstudio.com"
+	DEFAULT_ADO_BASE_URL         = "https://app.vssps.visualstudio.com"
+	DEFAULT_ADO_CONCURRENCY      = 8
+	DEFAULT_ADO_ORGANIZATION_URL = "https://dev.azure.com"
 )
 
 var (
@@ -41,16 +47,34 @@ var (
 )
 
 type AzureDevOpsScmIntegration struct {
-	BaseUrl       string
-	pat           string
-	connection    *azuredevops.Connection
-	clientContext *context.Context
-	profileId     *uuid.UUID
+	BaseUrl string
+	// Collections are the URLs of Azure DevOps Server (on-premises) Project Collections to load, e.g.
+	// https://tfs.example.com/tfs/DefaultCollection - when set, Projects are listed from each Collection
+	// directly rather than from the Azure DevOps Services Organizations the PAT can see
+	Collections []string
+	// Organizations names the Azure DevOps Services Organizations to load (by name, or URL such as
+	// https://dev.azure.com/acme) - when empty, every Organization the PAT's user belongs to is loaded
+	Organizations []string
+	// Concurrency is the number of Azure DevOps requests made in parallel while loading
+	Concurrency int
+	// Filters decide which Organizations, Projects and Repositories are loaded - Projects of excluded
+	// Organizations and Repositories of excluded Projects are never requested
+	Filters Filters
+	// IncludeDisabled, IncludeEmpty and IncludeForks onboard Repositories that are otherwise skipped: those
+	// that are disabled, have no Default Branch (and so nothing to scan) or are forks
+	IncludeDisabled bool
+	IncludeEmpty    bool
+	IncludeForks    bool
+	pat             string
+	connection      *azuredevops.Connection
+	clientContext   *context.Context
+	profileId       *uuid.UUID
 }
 
 func NewAzureDevOpsScmIntegration(pat string, baseUrl *string) *AzureDevOpsScmIntegration {
 	scm := &AzureDevOpsScmIntegration{
-		pat: pat,
+		Concurrency: DEFAULT_ADO_CONCURRENCY,
+		pat:         pat,
 	}
 	if baseUrl == nil {
 		scm.BaseUrl = DEFAULT_ADO_BASE_URL
@@ -58,6 +82,8 @@ func NewAzureDevOpsScmIntegration(pat string, baseUrl *string) *AzureDevOpsScmIn
 		scm.BaseUrl = *baseUrl
 	}
 
+	// The Azure DevOps SDK creates its own HTTP clients, so retries can only be added to the default transport
+	util.UseRetryingDefaultTransport()
 	scm.connection = azuredevops.NewPatConnection(scm.BaseUrl, scm.pat)
 	ctx := context.Background()
 	scm.clientContext = &ctx
@@ -65,84 +91,189 @@ func NewAzureDevOpsScmIntegration(pat string, baseUrl *string) *AzureDevOpsScmIn
 	return scm
 }
 
-func (scm *AzureDevOpsScmIntegration) GetMappedAsOrgContents() (*OrgContents, error) {
-	orgContents := OrgContents{}
+// azureAccount holds the connection and clients reused for every request made to one Azure DevOps Organization.
+type azureAccount struct {
+	account    accounts.Account
+	coreClient core.Client
+	gitClient  git.Client
+	projects   []core.TeamProjectReference
+}
 
-	azureOrgs, err := scm.getOrganisations()
+/**
+ * Loads Projects for every Azure DevOps Organization (or Azure DevOps Server Collection), and then Repositories for every Project, spreading
+ * requests across `Concurrency` workers. Organizations, Projects and Repositories are sorted by name so
+ * the result does not depend on the order requests complete in.
+ */
+func (scm *AzureDevOpsScmIntegration) GetMappedAsOrgContents() (*OrgContents, error) {
+	var allAzureOrgs *[]accounts.Account
+	var err error
+	switch {
+	case len(scm.Collections) > 0 && len(scm.Organizations) > 0:
+		return nil, fmt.Errorf("Azure DevOps Organizations cannot be named when loading Azure DevOps Server Collections")
+	case len(scm.Collections) > 0:
+		allAzureOrgs, err = scm.getCollections()
+	case len(scm.Organizations) > 0:
+		allAzureOrgs, err = scm.getNamedOrganisations()
+	default:
+		allAzureOrgs, err = scm.getOrganisations()
+	}
 	if err != nil {
 		return nil, err
 	}
+	azureOrgs := make([]accounts.Account, 0, len(*allAzureOrgs))
+	for _, azureOrg := range *allAzureOrgs {
+		if scm.Filters.ExcludesOrganization([]string{*azureOrg.AccountName}) {
+			log.Debug(fmt.Sprintf("Azure DevOps - Organization %s excluded by filters", *azureOrg.AccountName))
+			continue
+		}
+		azureOrgs = append(azureOrgs, azureOrg)
+	}
+	sort.Slice(azureOrgs, func(i, j int) bool {
+		return lessFold(*azureOrgs[i].AccountName, *azureOrgs[j].AccountName)
+	})
 
-	for _, azureOrg := range *azureOrgs {
-		subOrgs, err := scm.getSubOrganizationsForAzureAccount(&azureOrg)
+	azureAccounts := make([]*azureAccount, len(azureOrgs))
+	pool := util.NewWorkerPool(scm.Concurrency)
+	for i, azureOrg := range azureOrgs {
+		err = pool.Submit(func() error {
+			account, err := scm.newAzureAccount(azureOrg)
+			if err != nil {
+				return err
+			}
+			projects, err := scm.getProjectsForAccount(account)
+			for _, project := range projects {
+				if scm.Filters.ExcludesOrganization([]string{*azureOrg.AccountName, *project.Name}) {
+					log.Debug(fmt.Sprintf("Azure DevOps - Project %s/%s excluded by filters", *azureOrg.AccountName, *project.Name))
+					continue
+				}
+				account.projects = append(account.projects, project)
+			}
+			azureAccounts[i] = account
+			return err
+		})
 		if err != nil {
-			return nil, err
+			break
 		}
+	}
+	err = pool.Wait()
+	if err != nil {
+		return nil, err
+	}
 
-		org := Organization{
-			Name:             *azureOrg.AccountName,
-			ScmProvider:      SCM_TYPE_AZURE,
-			SubOrganizations: *subOrgs,
+	orgContents := OrgContents{
+		Organizations: make([]Organization, len(azureAccounts)),
+	}
+	pool = util.NewWorkerPool(scm.Concurrency)
+	for i, account := range azureAccounts {
+		subOrgs := make([]Organization, len(account.projects))
+		orgContents.Organizations[i] = Organization{
+			Name:                  *account.account.AccountName,
+			ScmProvider:           SCM_TYPE_AZURE,
+			ApplyScmConfiguration: true,
+			SubOrganizations:      subOrgs,
 		}
 
-		orgContents.Organizations = append(orgContents.Organizations, org)
+		for j, project := range account.projects {
+			err = pool.Submit(func() error {
+				apps, skipped, err := scm.getApplicationsForProject(account, &project)
+				if err != nil {
+					return err
+				}
+				subOrgs[j] = Organization{
+					Name:                *project.Name,
+					ScmProvider:         SCM_TYPE_AZURE,
+					Applications:        apps,
+					SkippedApplications: skipped,
+				}
+				return nil
+			})
+			if err != nil {
+				break
+			}
+		}
+	}
+	err = pool.Wait()
+	if err != nil {
+		return nil, err
 	}
 
-	return &orgContents, nil
+	return orgContents.Filter(scm.Filters), nil
 }
 
-func (scm *AzureDevOpsScmIntegration) GetScmConfig() *ScmConfiguration {
-	return &ScmConfiguration{
-		Username: "noone@nowhere.tld",
-		Password: scm.pat,
-		Type:     SCM_TYPE_AZURE,
+func (scm *AzureDevOpsScmIntegration) newAzureAccount(account accounts.Account) (*azureAccount, error) {
+	accountConnection := azuredevops.NewPatConnection(*account.AccountUri, scm.pat)
+	coreClient, err := core.NewClient(*scm.clientContext, accountConnection)
+	if err != nil {
+		return nil, err
 	}
-}
-
-func (scm *AzureDevOpsScmIntegration) getSubOrganizationsForAzureAccount(account *accounts.Account) (*[]Organization, error) {
-	projects, err := scm.getProjectsForAccount(account)
+	gitClient, err := git.NewClient(*scm.clientContext, accountConnection)
 	if err != nil {
 		return nil, err
 	}
 
-	orgs := make([]Organization, 0)
-	for _, o := range *projects {
-		apps, err := scm.getApplicationsForProject(account, &o)
-		if err != nil {
-			return nil, err
-		}
+	return &azureAccount{
+		account:    account,
+		coreClient: coreClient,
+		gitClient:  gitClient,
+	}, nil
+}
 
-		org := Organization{
-			Name:         *o.Name,
-			ScmProvider:  SCM_TYPE_AZURE,
-			Applications: *apps,
-		}
-		orgs = append(orgs, org)
+func (scm *AzureDevOpsScmIntegration) GetScmConfig() *ScmConfiguration {
+	return &ScmConfiguration{
+		Username: "noone@nowhere.tld",
+		Password: scm.pat,
+		Type:     SCM_TYPE_AZURE,
 	}
-
-	return &orgs, nil
 }
 
-func (scm *AzureDevOpsScmIntegration) getApplicationsForProject(account *accounts.Account, project *core.TeamProjectReference) (*[]Application, error) {
-	repos, err := scm.getRepositoriesForProjectForAccount(*account.AccountUri, project.Id)
+// getApplicationsForProject maps the Repositories of a Project, returning separately those skipped as
+// disabled, empty or forks.
+func (scm *AzureDevOpsScmIntegration) getApplicationsForProject(account *azureAccount, project *core.TeamProjectReference) ([]Application, []SkippedApplication, error) {
+	repos, err := scm.getRepositoriesForProject(account, project.Id)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	sort.Slice(*repos, func(i, j int) bool {
+		return lessFold(*(*repos)[i].Name, *(*repos)[j].Name)
+	})
 
 	apps := make([]Application, 0)
+	skipped := make([]SkippedApplication, 0)
 	for _, repo := range *repos {
 		appDto := Application{
 			Name:          *repo.Name,
 			RepositoryUrl: *repo.WebUrl,
 		}
+		if repo.Id != nil {
+			appDto.ScmId = repo.Id.String()
+		}
 		if repo.DefaultBranch != nil {
 			defaultBranch := strings.Replace(*repo.DefaultBranch, "refs/heads/", "", 1)
 			appDto.DefaultBranch = &defaultBranch
 		}
+
+		if reason := scm.skipReason(&repo); reason != "" {
+			log.Info(fmt.Sprintf("Azure DevOps - Skipping Repository %s/%s/%s: %s", *account.account.AccountName, *project.Name, *repo.Name, reason))
+			skipped = append(skipped, SkippedApplication{Application: appDto, Reason: reason})
+			continue
+		}
 		apps = append(apps, appDto)
 	}
 
-	return &apps, nil
+	return apps, skipped, nil
+}
+
+// skipReason says why a Repository is not to be onboarded, or is empty if it is.
+func (scm *AzureDevOpsScmIntegration) skipReason(repo *git.GitRepository) string {
+	switch {
+	case repo.IsDisabled != nil && *repo.IsDisabled && !scm.IncludeDisabled:
+		return "repository is disabled"
+	case repo.DefaultBranch == nil && !scm.IncludeEmpty:
+		return "repository is empty (no Default Branch)"
+	case repo.IsFork != nil && *repo.IsFork && !scm.IncludeForks:
+		return "repository is a fork"
+	}
+	return ""
 }
 
 func (scm *AzureDevOpsScmIntegration) getOrganisations() (*[]accounts.Account, error) {
@@ -161,6 +292,112 @@ func (scm *AzureDevOpsScmIntegration) getOrganisations() (*[]accounts.Account, e
 	return accounts, nil
 }
 
+/**
+ * Describes each of the named Organizations as an Account, checking that each exists and that the PAT can
+ * list its Projects. Every Organization that cannot be reached is reported at once.
+ */
+func (scm *AzureDevOpsScmIntegration) getNamedOrganisations() (*[]accounts.Account, error) {
+	log.Debug("Azure DevOps - Loading named Organisations")
+
+	azureOrgs := make([]accounts.Account, 0, len(scm.Organizations))
+	seen := make(map[string]bool)
+	problems := make([]string, 0)
+	for _, nameOrUrl := range scm.Organizations {
+		azureOrg, err := namedOrganisationAccount(nameOrUrl)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if seen[strings.ToLower(*azureOrg.AccountName)] {
+			continue
+		}
+		seen[strings.ToLower(*azureOrg.AccountName)] = true
+
+		account, err := scm.newAzureAccount(azureOrg)
+		if err == nil {
+			top := 1
+			_, err = account.coreClient.GetProjects(*scm.clientContext, core.GetProjectsArgs{Top: &top})
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s) does not exist or is not visible with the PAT: %v", *azureOrg.AccountName, *azureOrg.AccountUri, err))
+			continue
+		}
+		azureOrgs = append(azureOrgs, azureOrg)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Azure DevOps Organizations could not be loaded:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return &azureOrgs, nil
+}
+
+/**
+ * Describes an Azure DevOps Organization given by name (acme), or by URL in either the current
+ * (https://dev.azure.com/acme) or legacy (https://acme.visualstudio.com) form, as an Account.
+ */
+func namedOrganisationAccount(nameOrUrl string) (accounts.Account, error) {
+	name, uri := strings.Trim(nameOrUrl, "/ "), ""
+	if strings.Contains(name, "://") {
+		u, err := url.Parse(name)
+		if err != nil || u.Host == "" {
+			return accounts.Account{}, fmt.Errorf("'%s' is not a valid Azure DevOps Organization URL", nameOrUrl)
+		}
+		if legacyName, ok := strings.CutSuffix(strings.ToLower(u.Hostname()), ".visualstudio.com"); ok {
+			name = legacyName
+			uri = fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+		} else {
+			name, _, _ = strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+			uri = fmt.Sprintf("%s://%s/%s", u.Scheme, u.Host, name)
+		}
+	} else {
+		uri = fmt.Sprintf("%s/%s", DEFAULT_ADO_ORGANIZATION_URL, name)
+	}
+
+	if name == "" || strings.ContainsAny(name, "/ ") {
+		return accounts.Account{}, fmt.Errorf("'%s' is not a valid Azure DevOps Organization name or URL", nameOrUrl)
+	}
+	return accounts.Account{AccountName: &name, AccountUri: &uri}, nil
+}
+
+/**
+ * Describes each Azure DevOps Server Collection as an Account, named after the last segment of its URL -
+ * so each Collection becomes a top level Organization. Azure DevOps Server has no Profile or Accounts
+ * APIs to discover them with.
+ */
+func (scm *AzureDevOpsScmIntegration) getCollections() (*[]accounts.Account, error) {
+	log.Debug("Azure DevOps - Loading Projects from Collections")
+
+	collections := make([]accounts.Account, 0, len(scm.Collections))
+	urlsByName := make(map[string]string)
+	for _, collectionUrl := range scm.Collections {
+		collection, err := collectionAccount(collectionUrl)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := urlsByName[*collection.AccountName]; ok {
+			return nil, fmt.Errorf("Azure DevOps Collections %s and %s are both named %s", other, collectionUrl, *collection.AccountName)
+		}
+		urlsByName[*collection.AccountName] = collectionUrl
+		collections = append(collections, collection)
+	}
+
+	return &collections, nil
+}
+
+func collectionAccount(collectionUrl string) (accounts.Account, error) {
+	u, err := url.Parse(strings.TrimRight(collectionUrl, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return accounts.Account{}, fmt.Errorf("'%s' is not a valid Azure DevOps Server Collection URL", collectionUrl)
+	}
+
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		name = u.Hostname()
+	}
+	uri := u.String()
+	return accounts.Account{AccountName: &name, AccountUri: &uri}, nil
+}
+
 func (scm *AzureDevOpsScmIntegration) getProfile() (*profile.Profile, error) {
 	pClient, err := profile.NewClient(*scm.clientContext, scm.connection)
 	if err != nil {
@@ -195,13 +432,8 @@ func (scm *AzureDevOpsScmIntegration) getAccounts() (*[]accounts.Account, error)
 	return accounts, nil
 }
 
-func (scm *AzureDevOpsScmIntegration) getProjectsForAccount(account *accounts.Account) (*[]core.TeamProjectReference, error) {
-	accountConnection := azuredevops.NewPatConnection(*account.AccountUri, scm.pat)
-	coreClient, err := core.NewClient(*scm.clientContext, accountConnection)
-	if err != nil {
-		return nil, err
-	}
-
+func (scm *AzureDevOpsScmIntegration) getProjectsForAccount(azureAccount *azureAccount) ([]core.TeamProjectReference, error) {
+	account, coreClient := azureAccount.account, azureAccount.coreClient
 	responseValue, err := coreClient.GetProjects(*scm.clientContext, core.GetProjectsArgs{})
 	if err != nil {
 		return nil, err
@@ -250,20 +482,17 @@ func (scm *AzureDevOpsScmIntegration) getProjectsForAccount(account *accounts.Ac
 		}
 	}
 
-	return &allProjects, nil
+	sort.Slice(allProjects, func(i, j int) bool {
+		return lessFold(*allProjects[i].Name, *allProjects[j].Name)
+	})
+	return allProjects, nil
 }
 
-func (scm *AzureDevOpsScmIntegration) getRepositoriesForProjectForAccount(accountUri string, projectId *uuid.UUID) (*[]git.GitRepository, error) {
+func (scm *AzureDevOpsScmIntegration) getRepositoriesForProject(account *azureAccount, projectId *uuid.UUID) (*[]git.GitRepository, error) {
 	log.Debug(fmt.Sprintf("Getting Repositories for Project %v", projectId))
-	accountConnection := azuredevops.NewPatConnection(accountUri, scm.pat)
-	gClient, err := git.NewClient(*scm.clientContext, accountConnection)
-	if err != nil {
-		return nil, err
-	}
-
 	pid := projectId.String()
 
-	repositories, err := gClient.GetRepositories(*scm.clientContext, git.GetRepositoriesArgs{
+	repositories, err := account.gitClient.GetRepositories(*scm.clientContext, git.GetRepositoriesArgs{
 		Project: &pid,
 	})
 	if err != nil {
@@ -273,6 +502,71 @@ func (scm *AzureDevOpsScmIntegration) getRepositoriesForProjectForAccount(accoun
 	return repositories, nil
 }
 
-func (scm *AzureDevOpsScmIntegration) ValidateConnection() (bool, error) {
-	return false, nil
+// lessFold orders names case-insensitively, falling back to case-sensitive order for names that differ only in case.
+func lessFold(a string, b string) bool {
+	if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+/**
+ * Checks the PAT can authenticate and list the Organizations (or Collections) to load, then that it can
+ * read the Projects of each and the Repositories of its first Project - which needs the Code (Read) scope.
+ */
+func (scm *AzureDevOpsScmIntegration) ValidateConnection() *util.Checklist {
+	checks := util.NewChecklist()
+
+	var azureOrgs *[]accounts.Account
+	var description string
+	var err error
+	switch {
+	case len(scm.Collections) > 0:
+		description = "Read Azure DevOps Server Collection URLs"
+		azureOrgs, err = scm.getCollections()
+	case len(scm.Organizations) > 0:
+		description = "Reach the named Azure DevOps Organizations"
+		azureOrgs, err = scm.getNamedOrganisations()
+	default:
+		_, err = scm.getProfile()
+		if !checks.Check("Authenticate to Azure DevOps", "", err) {
+			return checks
+		}
+		description = "List Azure DevOps Organizations"
+		azureOrgs, err = scm.getAccounts()
+	}
+	if err != nil {
+		checks.Check(description, "", err)
+		return checks
+	}
+	names := make([]string, 0, len(*azureOrgs))
+	for _, azureOrg := range *azureOrgs {
+		names = append(names, *azureOrg.AccountName)
+	}
+	checks.Check(description, describeNames("Organizations", names), nil)
+
+	for _, azureOrg := range *azureOrgs {
+		account, err := scm.newAzureAccount(azureOrg)
+		var projects []core.TeamProjectReference
+		if err == nil {
+			projects, err = scm.getProjectsForAccount(account)
+		}
+		if !checks.Check(fmt.Sprintf("Read Projects in %s", *azureOrg.AccountName), fmt.Sprintf("%d Projects", len(projects)), err) {
+			continue
+		}
+		if len(projects) == 0 {
+			continue
+		}
+
+		repos, err := scm.getRepositoriesForProject(account, projects[0].Id)
+		detail := ""
+		if err == nil {
+			detail = fmt.Sprintf("%d Repositories in %s", len(*repos), *projects[0].Name)
+		} else {
+			err = fmt.Errorf("%v - the PAT needs the Code (Read) scope", err)
+		}
+		checks.Check(fmt.Sprintf("Read Repositories in %s", *azureOrg.AccountName), detail, err)
+	}
+
+	return checks
 }
@@ -0,0 +1,80 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureDevOpsCollections(t *testing.T) {
+	scm := NewAzureDevOpsScmIntegration("pat", nil)
+	scm.Collections = []string{"https://tfs.example.com/tfs/DefaultCollection/", "https://ado.example.com"}
+
+	collections, err := scm.getCollections()
+	assert.NoError(t, err)
+	assert.Len(t, *collections, 2)
+	assert.Equal(t, "DefaultCollection", *(*collections)[0].AccountName)
+	assert.Equal(t, "https://tfs.example.com/tfs/DefaultCollection", *(*collections)[0].AccountUri)
+	assert.Equal(t, "ado.example.com", *(*collections)[1].AccountName)
+
+	scm.Collections = []string{"https://tfs.example.com/tfs/DefaultCollection", "https://other.example.com/DefaultCollection"}
+	_, err = scm.getCollections()
+	assert.ErrorContains(t, err, "are both named DefaultCollection")
+
+	scm.Collections = []string{"tfs.example.com/DefaultCollection"}
+	_, err = scm.getCollections()
+	assert.ErrorContains(t, err, "not a valid Azure DevOps Server Collection URL")
+}
+
+func TestAzureDevOpsNamedOrganisationAccount(t *testing.T) {
+	for nameOrUrl, expected := range map[string][2]string{
+		"acme":                                 {"acme", "https://dev.azure.com/acme"},
+		"https://dev.azure.com/acme/":          {"acme", "https://dev.azure.com/acme"},
+		"https://dev.azure.com/acme/Platform":  {"acme", "https://dev.azure.com/acme"},
+		"https://Acme.visualstudio.com/":       {"acme", "https://Acme.visualstudio.com"},
+		"https://ado.example.com/acme?x=1#top": {"acme", "https://ado.example.com/acme"},
+	} {
+		account, err := namedOrganisationAccount(nameOrUrl)
+		assert.NoError(t, err, nameOrUrl)
+		assert.Equal(t, expected[0], *account.AccountName, nameOrUrl)
+		assert.Equal(t, expected[1], *account.AccountUri, nameOrUrl)
+	}
+
+	for _, invalid := range []string{"", "https://dev.azure.com/", "acme corp"} {
+		_, err := namedOrganisationAccount(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestAzureDevOpsNamedOrganisationsNotVisible(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	scm := NewAzureDevOpsScmIntegration("pat", nil)
+	scm.Organizations = []string{server.URL + "/acme", server.URL + "/other"}
+	_, err := scm.getNamedOrganisations()
+	assert.ErrorContains(t, err, "acme ("+server.URL+"/acme) does not exist or is not visible with the PAT")
+	assert.ErrorContains(t, err, "other ("+server.URL+"/other) does not exist or is not visible with the PAT")
+
+	scm.Collections = []string{server.URL + "/DefaultCollection"}
+	_, err = scm.GetMappedAsOrgContents()
+	assert.ErrorContains(t, err, "cannot be named when loading Azure DevOps Server Collections")
+}
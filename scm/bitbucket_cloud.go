@@ -0,0 +1,307 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
+const (
+	DEFAULT_BITBUCKET_CLOUD_BASE_URL = "https://api.bitbucket.org/2.0"
+	BITBUCKET_CLOUD_PAGE_SIZE        = 100
+)
+
+type bitbucketCloudWorkspace struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+type bitbucketCloudProject struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+type bitbucketCloudRepository struct {
+	Uuid       string `json:"uuid"`
+	Name       string `json:"name"`
+	Slug       string `json:"slug"`
+	MainBranch *struct {
+		Name string `json:"name"`
+	} `json:"mainbranch"`
+	Links struct {
+		Html struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+type bitbucketCloudWorkspacePage struct {
+	Values []struct {
+		Workspace bitbucketCloudWorkspace `json:"workspace"`
+	} `json:"values"`
+	Next string `json:"next"`
+}
+
+type bitbucketCloudProjectPage struct {
+	Values []bitbucketCloudProject `json:"values"`
+	Next   string                  `json:"next"`
+}
+
+type bitbucketCloudRepositoryPage struct {
+	Values []bitbucketCloudRepository `json:"values"`
+	Next   string                     `json:"next"`
+}
+
+type BitbucketCloudScmIntegration struct {
+	BaseUrl   string
+	Workspace string
+	// Filters decide which Workspaces, Projects and Repositories are loaded
+	Filters    Filters
+	username   string
+	token      string
+	httpClient *http.Client
+}
+
+// NewBitbucketCloudScmIntegration creates an integration for Bitbucket Cloud.
+//
+// If username is supplied, token is treated as an App Password, otherwise it is treated as a
+// Workspace (or Project/Repository) Access Token. Access Tokens cannot list Workspaces, so
+// Workspace must also be set when using one.
+func NewBitbucketCloudScmIntegration(username string, token string, baseUrl *string) *BitbucketCloudScmIntegration {
+	scm := &BitbucketCloudScmIntegration{
+		username:   username,
+		token:      token,
+		httpClient: util.NewRetryingHttpClient(util.DefaultRetryPolicy),
+	}
+	if baseUrl == nil {
+		scm.BaseUrl = DEFAULT_BITBUCKET_CLOUD_BASE_URL
+	} else {
+		scm.BaseUrl = strings.TrimRight(*baseUrl, "/")
+	}
+
+	return scm
+}
+
+func (scm *BitbucketCloudScmIntegration) GetMappedAsOrgContents() (*OrgContents, error) {
+	orgContents := OrgContents{}
+
+	workspaces, err := scm.getWorkspaces()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, workspace := range *workspaces {
+		if scm.Filters.ExcludesOrganization([]string{workspace.Name}) {
+			log.Debug(fmt.Sprintf("Bitbucket Cloud - Workspace %s excluded by filters", workspace.Name))
+			continue
+		}
+
+		subOrgs, err := scm.getSubOrganizationsForWorkspace(&workspace)
+		if err != nil {
+			return nil, err
+		}
+
+		org := Organization{
+			Name:                  workspace.Name,
+			ScmProvider:           SCM_TYPE_BITBUCKET,
+			ApplyScmConfiguration: true,
+			SubOrganizations:      *subOrgs,
+		}
+
+		orgContents.Organizations = append(orgContents.Organizations, org)
+	}
+
+	return orgContents.Filter(scm.Filters), nil
+}
+
+func (scm *BitbucketCloudScmIntegration) GetScmConfig() *ScmConfiguration {
+	return &ScmConfiguration{
+		Username: scm.username,
+		Password: scm.token,
+		Type:     SCM_TYPE_BITBUCKET,
+	}
+}
+
+func (scm *BitbucketCloudScmIntegration) getSubOrganizationsForWorkspace(workspace *bitbucketCloudWorkspace) (*[]Organization, error) {
+	projects, err := scm.getProjectsForWorkspace(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs := make([]Organization, 0)
+	for _, p := range *projects {
+		if scm.Filters.ExcludesOrganization([]string{workspace.Name, p.Name}) {
+			log.Debug(fmt.Sprintf("Bitbucket Cloud - Project %s/%s excluded by filters", workspace.Name, p.Name))
+			continue
+		}
+
+		apps, err := scm.getApplicationsForProject(workspace, &p)
+		if err != nil {
+			return nil, err
+		}
+
+		org := Organization{
+			Name:         p.Name,
+			ScmProvider:  SCM_TYPE_BITBUCKET,
+			Applications: *apps,
+		}
+		orgs = append(orgs, org)
+	}
+
+	return &orgs, nil
+}
+
+func (scm *BitbucketCloudScmIntegration) getApplicationsForProject(workspace *bitbucketCloudWorkspace, project *bitbucketCloudProject) (*[]Application, error) {
+	repos, err := scm.getRepositoriesForProject(workspace, project)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := make([]Application, 0)
+	for _, repo := range *repos {
+		appDto := Application{
+			Name:          repo.Name,
+			RepositoryUrl: repo.Links.Html.Href,
+		}
+		if uuid := strings.Trim(repo.Uuid, "{}"); uuid != "" {
+			appDto.ScmId = uuid
+		}
+		if repo.MainBranch != nil && repo.MainBranch.Name != "" {
+			defaultBranch := repo.MainBranch.Name
+			appDto.DefaultBranch = &defaultBranch
+		}
+		apps = append(apps, appDto)
+	}
+
+	return &apps, nil
+}
+
+func (scm *BitbucketCloudScmIntegration) getWorkspaces() (*[]bitbucketCloudWorkspace, error) {
+	if strings.TrimSpace(scm.Workspace) != "" {
+		log.Debug(fmt.Sprintf("Bitbucket Cloud - Loading requested Workspace %s", scm.Workspace))
+		var workspace bitbucketCloudWorkspace
+		_, err := getJson(scm.httpClient, fmt.Sprintf("%s/workspaces/%s", scm.BaseUrl, url.PathEscape(scm.Workspace)), scm.headers(), &workspace)
+		if err != nil {
+			return nil, err
+		}
+		return &[]bitbucketCloudWorkspace{workspace}, nil
+	}
+
+	log.Debug("Bitbucket Cloud - Loading Workspaces")
+	allWorkspaces := make([]bitbucketCloudWorkspace, 0)
+	nextUrl := fmt.Sprintf("%s/user/permissions/workspaces?pagelen=%d", scm.BaseUrl, BITBUCKET_CLOUD_PAGE_SIZE)
+	for nextUrl != "" {
+		var page bitbucketCloudWorkspacePage
+		_, err := getJson(scm.httpClient, nextUrl, scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page.Values {
+			allWorkspaces = append(allWorkspaces, v.Workspace)
+		}
+		nextUrl = page.Next
+	}
+
+	log.Debug(fmt.Sprintf("Found %d Bitbucket Cloud Workspaces", len(allWorkspaces)))
+	return &allWorkspaces, nil
+}
+
+func (scm *BitbucketCloudScmIntegration) getProjectsForWorkspace(workspace *bitbucketCloudWorkspace) (*[]bitbucketCloudProject, error) {
+	log.Debug(fmt.Sprintf("Getting Projects for Bitbucket Cloud Workspace %s", workspace.Slug))
+
+	allProjects := make([]bitbucketCloudProject, 0)
+	nextUrl := fmt.Sprintf("%s/workspaces/%s/projects?pagelen=%d", scm.BaseUrl, url.PathEscape(workspace.Slug), BITBUCKET_CLOUD_PAGE_SIZE)
+	for nextUrl != "" {
+		var page bitbucketCloudProjectPage
+		_, err := getJson(scm.httpClient, nextUrl, scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		allProjects = append(allProjects, page.Values...)
+		nextUrl = page.Next
+	}
+
+	log.Debug(fmt.Sprintf("Found %d Projects in Bitbucket Cloud Workspace %s", len(allProjects), workspace.Slug))
+	return &allProjects, nil
+}
+
+func (scm *BitbucketCloudScmIntegration) getRepositoriesForProject(workspace *bitbucketCloudWorkspace, project *bitbucketCloudProject) (*[]bitbucketCloudRepository, error) {
+	log.Debug(fmt.Sprintf("Getting Repositories for Bitbucket Cloud Project %s/%s", workspace.Slug, project.Key))
+
+	query := url.QueryEscape(fmt.Sprintf(`project.key="%s"`, project.Key))
+	allRepos := make([]bitbucketCloudRepository, 0)
+	nextUrl := fmt.Sprintf("%s/repositories/%s?q=%s&pagelen=%d", scm.BaseUrl, url.PathEscape(workspace.Slug), query, BITBUCKET_CLOUD_PAGE_SIZE)
+	for nextUrl != "" {
+		var page bitbucketCloudRepositoryPage
+		_, err := getJson(scm.httpClient, nextUrl, scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		allRepos = append(allRepos, page.Values...)
+		nextUrl = page.Next
+	}
+
+	log.Debug(fmt.Sprintf("Found %d Repositories in Bitbucket Cloud Project %s/%s", len(allRepos), workspace.Slug, project.Key))
+	return &allRepos, nil
+}
+
+func (scm *BitbucketCloudScmIntegration) headers() map[string]string {
+	if scm.username != "" {
+		return map[string]string{
+			"Authorization": fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(scm.username+":"+scm.token))),
+		}
+	}
+	return map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", scm.token),
+	}
+}
+
+// ValidateConnection checks the credentials can authenticate, list Workspaces and read the Projects and
+// Repositories of each.
+func (scm *BitbucketCloudScmIntegration) ValidateConnection() *util.Checklist {
+	checks := util.NewChecklist()
+
+	workspaces, err := scm.getWorkspaces()
+	if err != nil {
+		checks.Check("Authenticate to Bitbucket Cloud and list Workspaces", "", err)
+		return checks
+	}
+	log.Debug("Successfully connected to Bitbucket Cloud")
+	names := make([]string, 0, len(*workspaces))
+	for _, workspace := range *workspaces {
+		names = append(names, workspace.Slug)
+	}
+	checks.Check("Authenticate to Bitbucket Cloud and list Workspaces", describeNames("Workspaces", names), nil)
+
+	for _, workspace := range *workspaces {
+		var projects bitbucketCloudProjectPage
+		_, err := getJson(scm.httpClient, fmt.Sprintf("%s/workspaces/%s/projects?pagelen=1", scm.BaseUrl, url.PathEscape(workspace.Slug)), scm.headers(), &projects)
+		checks.Check(fmt.Sprintf("Read Projects in %s", workspace.Slug), "", err)
+
+		var repos bitbucketCloudRepositoryPage
+		_, err = getJson(scm.httpClient, fmt.Sprintf("%s/repositories/%s?pagelen=1", scm.BaseUrl, url.PathEscape(workspace.Slug)), scm.headers(), &repos)
+		checks.Check(fmt.Sprintf("Read Repositories in %s", workspace.Slug), "", err)
+	}
+	return checks
+}
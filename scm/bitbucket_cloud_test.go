@@ -0,0 +1,100 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitbucketCloudMappedAsOrgContents(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "jdoe", username)
+		assert.Equal(t, "app-password", password)
+
+		switch r.URL.Path {
+		case "/user/permissions/workspaces":
+			fmt.Fprint(w, `{"values":[{"workspace":{"slug":"acme","name":"Acme Corp"}}]}`)
+		case "/workspaces/acme/projects":
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `{"values":[{"key":"WEB","name":"Web"}]}`)
+				return
+			}
+			fmt.Fprintf(w, `{"values":[{"key":"MOB","name":"Mobile"}],"next":"%s/workspaces/acme/projects?page=2"}`, server.URL)
+		case "/repositories/acme":
+			switch r.URL.Query().Get("q") {
+			case `project.key="MOB"`:
+				fmt.Fprint(w, `{"values":[{"uuid":"{B9C5A1E2-3F4D-4E6A-9B8C-7D6E5F4A3B2C}","name":"ios-app","slug":"ios-app","mainbranch":{"name":"main"},"links":{"html":{"href":"https://bitbucket.org/acme/ios-app"}}}]}`)
+			default:
+				fmt.Fprint(w, `{"values":[{"name":"site","slug":"site","links":{"html":{"href":"https://bitbucket.org/acme/site"}}}]}`)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	orgContents, err := NewBitbucketCloudScmIntegration("jdoe", "app-password", &server.URL).GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	assert.Len(t, orgContents.Organizations, 1)
+
+	workspace := orgContents.Organizations[0]
+	assert.Equal(t, "Acme Corp", workspace.Name)
+	assert.Len(t, workspace.Applications, 0)
+	assert.Len(t, workspace.SubOrganizations, 2)
+
+	mobile := workspace.SubOrganizations[0]
+	assert.Equal(t, "Mobile", mobile.Name)
+	assert.Equal(t, "ios-app", mobile.Applications[0].Name)
+	assert.Equal(t, "main", *mobile.Applications[0].DefaultBranch)
+	assert.Equal(t, "https://bitbucket.org/acme/ios-app", mobile.Applications[0].RepositoryUrl)
+	assert.Equal(t, "B9C5A1E2-3F4D-4E6A-9B8C-7D6E5F4A3B2C", mobile.Applications[0].ScmId)
+	assert.Equal(t, "repo-b9c5a1e2-3f4d-4e6a-9b8c-7d6e5f4a3b2c", mobile.Applications[0].StableId())
+
+	web := workspace.SubOrganizations[1]
+	assert.Equal(t, "Web", web.Name)
+	assert.Nil(t, web.Applications[0].DefaultBranch)
+}
+
+func TestBitbucketCloudAccessToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer workspace-token", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/workspaces/acme":
+			fmt.Fprint(w, `{"slug":"acme","name":"Acme Corp"}`)
+		case "/workspaces/acme/projects":
+			fmt.Fprint(w, `{"values":[]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	bitbucket := NewBitbucketCloudScmIntegration("", "workspace-token", &server.URL)
+	bitbucket.Workspace = "acme"
+	orgContents, err := bitbucket.GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	assert.Len(t, orgContents.Organizations, 1)
+	assert.Equal(t, "Acme Corp", orgContents.Organizations[0].Name)
+}
@@ -0,0 +1,265 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
+const (
+	BITBUCKET_SERVER_PAGE_SIZE = 100
+)
+
+type bitbucketServerLink struct {
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+type bitbucketServerProject struct {
+	Id   int    `json:"id"`
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+type bitbucketServerRepository struct {
+	Id    int    `json:"id"`
+	Slug  string `json:"slug"`
+	Name  string `json:"name"`
+	Links struct {
+		Clone []bitbucketServerLink `json:"clone"`
+		Self  []bitbucketServerLink `json:"self"`
+	} `json:"links"`
+}
+
+type bitbucketServerBranch struct {
+	Id        string `json:"id"`
+	DisplayId string `json:"displayId"`
+}
+
+type bitbucketServerProjectPage struct {
+	Values        []bitbucketServerProject `json:"values"`
+	IsLastPage    bool                     `json:"isLastPage"`
+	NextPageStart int                      `json:"nextPageStart"`
+}
+
+type bitbucketServerRepositoryPage struct {
+	Values        []bitbucketServerRepository `json:"values"`
+	IsLastPage    bool                        `json:"isLastPage"`
+	NextPageStart int                         `json:"nextPageStart"`
+}
+
+type BitbucketServerScmIntegration struct {
+	BaseUrl  string
+	Username string
+	// Filters decide which Projects and Repositories are loaded - the Default Branch of excluded
+	// Repositories is never requested
+	Filters    Filters
+	token      string
+	httpClient *http.Client
+}
+
+// NewBitbucketServerScmIntegration creates an integration for Bitbucket Server or Data Center
+// at baseUrl (e.g. https://bitbucket.example.com), authenticating with an HTTP access token.
+func NewBitbucketServerScmIntegration(token string, baseUrl string) *BitbucketServerScmIntegration {
+	return &BitbucketServerScmIntegration{
+		BaseUrl:    strings.TrimRight(baseUrl, "/"),
+		token:      token,
+		httpClient: util.NewRetryingHttpClient(util.DefaultRetryPolicy),
+	}
+}
+
+func (scm *BitbucketServerScmIntegration) GetMappedAsOrgContents() (*OrgContents, error) {
+	orgContents := OrgContents{}
+
+	projects, err := scm.getProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, project := range *projects {
+		if scm.Filters.ExcludesOrganization([]string{project.Name}) {
+			log.Debug(fmt.Sprintf("Bitbucket Server - Project %s excluded by filters", project.Name))
+			continue
+		}
+
+		apps, err := scm.getApplicationsForProject(&project)
+		if err != nil {
+			return nil, err
+		}
+
+		org := Organization{
+			Name:                  project.Name,
+			ScmProvider:           SCM_TYPE_BITBUCKET,
+			ApplyScmConfiguration: true,
+			Applications:          *apps,
+		}
+
+		orgContents.Organizations = append(orgContents.Organizations, org)
+	}
+
+	return orgContents.Filter(scm.Filters), nil
+}
+
+func (scm *BitbucketServerScmIntegration) GetScmConfig() *ScmConfiguration {
+	return &ScmConfiguration{
+		Username: scm.Username,
+		Password: scm.token,
+		Type:     SCM_TYPE_BITBUCKET,
+	}
+}
+
+func (scm *BitbucketServerScmIntegration) getApplicationsForProject(project *bitbucketServerProject) (*[]Application, error) {
+	repos, err := scm.getRepositoriesForProject(project)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := make([]Application, 0)
+	for _, repo := range *repos {
+		if !scm.Filters.IncludesApplication([]string{project.Name}, Application{Name: repo.Name, RepositoryUrl: repo.repositoryUrl()}) {
+			continue
+		}
+
+		defaultBranch, err := scm.getDefaultBranch(project, &repo)
+		if err != nil {
+			return nil, err
+		}
+
+		appDto := Application{
+			Name:          repo.Name,
+			RepositoryUrl: repo.repositoryUrl(),
+			DefaultBranch: defaultBranch,
+		}
+		if repo.Id != 0 {
+			appDto.ScmId = numericScmId(SCM_TYPE_BITBUCKET, appDto.RepositoryUrl, int64(repo.Id))
+		}
+		apps = append(apps, appDto)
+	}
+
+	return &apps, nil
+}
+
+func (scm *BitbucketServerScmIntegration) getProjects() (*[]bitbucketServerProject, error) {
+	log.Debug("Bitbucket Server - Loading Projects")
+
+	allProjects := make([]bitbucketServerProject, 0)
+	start := 0
+	for {
+		var page bitbucketServerProjectPage
+		_, err := getJson(scm.httpClient, fmt.Sprintf("%s/rest/api/1.0/projects?limit=%d&start=%d", scm.BaseUrl, BITBUCKET_SERVER_PAGE_SIZE, start), scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		allProjects = append(allProjects, page.Values...)
+		if page.IsLastPage {
+			break
+		}
+		start = page.NextPageStart
+	}
+
+	log.Debug(fmt.Sprintf("Found %d Bitbucket Server Projects", len(allProjects)))
+	return &allProjects, nil
+}
+
+func (scm *BitbucketServerScmIntegration) getRepositoriesForProject(project *bitbucketServerProject) (*[]bitbucketServerRepository, error) {
+	log.Debug(fmt.Sprintf("Getting Repositories for Bitbucket Server Project %s", project.Key))
+
+	allRepos := make([]bitbucketServerRepository, 0)
+	start := 0
+	for {
+		var page bitbucketServerRepositoryPage
+		_, err := getJson(scm.httpClient, fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos?limit=%d&start=%d", scm.BaseUrl, url.PathEscape(project.Key), BITBUCKET_SERVER_PAGE_SIZE, start), scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		allRepos = append(allRepos, page.Values...)
+		if page.IsLastPage {
+			break
+		}
+		start = page.NextPageStart
+	}
+
+	log.Debug(fmt.Sprintf("Found %d Repositories in Bitbucket Server Project %s", len(allRepos), project.Key))
+	return &allRepos, nil
+}
+
+// getDefaultBranch returns nil (and no error) for empty repositories, which have no default branch.
+func (scm *BitbucketServerScmIntegration) getDefaultBranch(project *bitbucketServerProject, repo *bitbucketServerRepository) (*string, error) {
+	var branch bitbucketServerBranch
+	resp, err := getJson(scm.httpClient, fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos/%s/default-branch", scm.BaseUrl, url.PathEscape(project.Key), url.PathEscape(repo.Slug)), scm.headers(), &branch)
+	if resp != nil && (resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound) {
+		log.Debug(fmt.Sprintf("Bitbucket Server Repository %s/%s has no default branch", project.Key, repo.Slug))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if branch.DisplayId == "" {
+		return nil, nil
+	}
+	return &branch.DisplayId, nil
+}
+
+// repositoryUrl prefers the HTTP(S) clone URL, falling back to the browse URL.
+func (repo *bitbucketServerRepository) repositoryUrl() string {
+	for _, l := range repo.Links.Clone {
+		if l.Name == "http" || l.Name == "https" {
+			return l.Href
+		}
+	}
+	if len(repo.Links.Self) > 0 {
+		return repo.Links.Self[0].Href
+	}
+	return ""
+}
+
+func (scm *BitbucketServerScmIntegration) headers() map[string]string {
+	return map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", scm.token),
+	}
+}
+
+// ValidateConnection checks the token can authenticate, list Projects and read the Repositories of each.
+func (scm *BitbucketServerScmIntegration) ValidateConnection() *util.Checklist {
+	checks := util.NewChecklist()
+
+	projects, err := scm.getProjects()
+	if err != nil {
+		checks.Check("Authenticate to Bitbucket Server and list Projects", "", err)
+		return checks
+	}
+	log.Debug(fmt.Sprintf("Successfully connected to Bitbucket Server at %s", scm.BaseUrl))
+	names := make([]string, 0, len(*projects))
+	for _, project := range *projects {
+		names = append(names, project.Key)
+	}
+	checks.Check("Authenticate to Bitbucket Server and list Projects", describeNames("Projects", names), nil)
+
+	for _, project := range *projects {
+		var page bitbucketServerRepositoryPage
+		_, err := getJson(scm.httpClient, fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos?limit=1", scm.BaseUrl, url.PathEscape(project.Key)), scm.headers(), &page)
+		checks.Check(fmt.Sprintf("Read Repositories in %s", project.Key), "", err)
+	}
+	return checks
+}
@@ -0,0 +1,105 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitbucketServerMappedAsOrgContents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/rest/api/1.0/projects":
+			if r.URL.Query().Get("start") == "1" {
+				fmt.Fprint(w, `{"values":[{"id":2,"key":"OPS","name":"Operations"}],"isLastPage":true}`)
+				return
+			}
+			fmt.Fprint(w, `{"values":[{"id":1,"key":"PAY","name":"Payments"}],"isLastPage":false,"nextPageStart":1}`)
+		case "/rest/api/1.0/projects/PAY/repos":
+			fmt.Fprint(w, `{"values":[
+				{"id":10,"slug":"ledger","name":"Ledger","links":{"clone":[{"href":"ssh://git@bitbucket.example.com:7999/pay/ledger.git","name":"ssh"},{"href":"https://bitbucket.example.com/scm/pay/ledger.git","name":"http"}],"self":[{"href":"https://bitbucket.example.com/projects/PAY/repos/ledger/browse"}]}},
+				{"id":11,"slug":"empty","name":"Empty","links":{"self":[{"href":"https://bitbucket.example.com/projects/PAY/repos/empty/browse"}]}}
+			],"isLastPage":true}`)
+		case "/rest/api/1.0/projects/OPS/repos":
+			fmt.Fprint(w, `{"values":[],"isLastPage":true}`)
+		case "/rest/api/1.0/projects/PAY/repos/ledger/default-branch":
+			fmt.Fprint(w, `{"id":"refs/heads/develop","displayId":"develop"}`)
+		case "/rest/api/1.0/projects/PAY/repos/empty/default-branch":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	orgContents, err := NewBitbucketServerScmIntegration("secret", server.URL).GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	assert.Len(t, orgContents.Organizations, 2)
+
+	payments := orgContents.Organizations[0]
+	assert.Equal(t, "Payments", payments.Name)
+	assert.Equal(t, SCM_TYPE_BITBUCKET, payments.ScmProvider)
+	assert.Len(t, payments.Applications, 2)
+	assert.Equal(t, "https://bitbucket.example.com/scm/pay/ledger.git", payments.Applications[0].RepositoryUrl)
+	assert.Equal(t, "develop", *payments.Applications[0].DefaultBranch)
+	assert.Equal(t, "bitbucket-bitbucket.example.com-10", payments.Applications[0].ScmId)
+	assert.Equal(t, "repo-bitbucket-bitbucket.example.com-10", payments.Applications[0].StableId())
+	assert.Equal(t, "https://bitbucket.example.com/projects/PAY/repos/empty/browse", payments.Applications[1].RepositoryUrl)
+	assert.Nil(t, payments.Applications[1].DefaultBranch)
+	assert.Equal(t, "Operations", orgContents.Organizations[1].Name)
+}
+
+func TestBitbucketServerFilters(t *testing.T) {
+	requested := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested[r.URL.Path] = true
+		switch r.URL.Path {
+		case "/rest/api/1.0/projects":
+			fmt.Fprint(w, `{"values":[{"id":1,"key":"PAY","name":"Payments"},{"id":2,"key":"OPS","name":"Operations Sandbox"}],"isLastPage":true}`)
+		case "/rest/api/1.0/projects/PAY/repos":
+			fmt.Fprint(w, `{"values":[
+				{"id":10,"slug":"ledger","name":"Ledger","links":{"self":[{"href":"https://bitbucket.example.com/projects/PAY/repos/ledger/browse"}]}},
+				{"id":11,"slug":"ledger-archive","name":"Ledger-archive","links":{"self":[{"href":"https://bitbucket.example.com/projects/PAY/repos/ledger-archive/browse"}]}}
+			],"isLastPage":true}`)
+		case "/rest/api/1.0/projects/PAY/repos/ledger/default-branch":
+			fmt.Fprint(w, `{"id":"refs/heads/main","displayId":"main"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	scm := NewBitbucketServerScmIntegration("secret", server.URL)
+	scm.Filters = Filters{
+		Exclude: []FilterRule{{Account: "* Sandbox"}, {Repository: "re:.*-(archive|old)"}},
+	}
+	orgContents, err := scm.GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	assert.Len(t, orgContents.Organizations, 1)
+	assert.Len(t, orgContents.Organizations[0].Applications, 1)
+	assert.Equal(t, "Ledger", orgContents.Organizations[0].Applications[0].Name)
+
+	// Nothing is requested for what is excluded
+	assert.False(t, requested["/rest/api/1.0/projects/OPS/repos"])
+	assert.False(t, requested["/rest/api/1.0/projects/PAY/repos/ledger-archive/default-branch"])
+}
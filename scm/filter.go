@@ -0,0 +1,283 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// FILTER_REGEX_PREFIX marks a pattern as a regular expression rather than a glob
+const FILTER_REGEX_PREFIX = "re:"
+
+// urlGlobSeparator stands in for `/` when matching a Repository URL against a glob, so that `*` and `?`
+// match across it
+const urlGlobSeparator = "\x00"
+
+var compiledFilterPatterns sync.Map
+
+// FilterRule matches Organizations and Applications using glob patterns (see path.Match), or regular
+// expressions when prefixed `re:`. Patterns must match the whole name - or the whole URL, for RepositoryUrl,
+// where `*` and `?` also match `/` (so `*-archive` matches https://github.com/acme/widget-archive). Every
+// pattern that is set must match for the rule to match.
+type FilterRule struct {
+	// Account matches the top level Organization - e.g. the Azure DevOps Organization, GitHub Organization,
+	// GitLab Group or Bitbucket Workspace
+	Account string `json:"account,omitempty" yaml:"account,omitempty"`
+	// Project matches the Organization beneath the Account - e.g. the Azure DevOps or Bitbucket Project
+	Project string `json:"project,omitempty" yaml:"project,omitempty"`
+	// Organization matches the name of the Organization an Application is in, or any above it
+	Organization  string `json:"organization,omitempty" yaml:"organization,omitempty"`
+	Repository    string `json:"repository,omitempty" yaml:"repository,omitempty"`
+	RepositoryUrl string `json:"repositoryUrl,omitempty" yaml:"repositoryUrl,omitempty"`
+}
+
+// Filters decide which Applications are onboarded: those matching any Include rule (or all, if there
+// are none) that match no Exclude rule.
+type Filters struct {
+	Include []FilterRule `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude []FilterRule `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+}
+
+func (f *Filters) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Merge returns Filters holding the rules of both.
+func (f Filters) Merge(other Filters) Filters {
+	return Filters{
+		Include: append(append([]FilterRule{}, f.Include...), other.Include...),
+		Exclude: append(append([]FilterRule{}, f.Exclude...), other.Exclude...),
+	}
+}
+
+// Validate returns a description of each rule that is empty or has a malformed pattern.
+func (f *Filters) Validate() []string {
+	problems := make([]string, 0)
+	for i, r := range f.Include {
+		problems = append(problems, r.validate(fmt.Sprintf("include[%d]", i))...)
+	}
+	for i, r := range f.Exclude {
+		problems = append(problems, r.validate(fmt.Sprintf("exclude[%d]", i))...)
+	}
+	return problems
+}
+
+// IncludesApplication decides whether an Application in the Organizations named by orgPath (outermost
+// first) is to be onboarded.
+func (f *Filters) IncludesApplication(orgPath []string, a Application) bool {
+	included := len(f.Include) == 0
+	for _, r := range f.Include {
+		if r.matchesApplication(orgPath, a) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	for _, r := range f.Exclude {
+		if r.matchesApplication(orgPath, a) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Decides whether nothing within the Organization named by orgPath (outermost first) can be onboarded -
+ * so SCM integrations can avoid loading its contents at all. That is when an Exclude rule that names only
+ * Organizations matches it, or when no Include rule could match anything within it.
+ */
+func (f *Filters) ExcludesOrganization(orgPath []string) bool {
+	for _, r := range f.Exclude {
+		if r.Repository == "" && r.RepositoryUrl == "" && r.matchesOrganization(orgPath) {
+			return true
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return false
+	}
+	for _, r := range f.Include {
+		if r.mayMatchWithin(orgPath) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the OrgContents holding only the Applications the Filters include. Organizations left
+// with nothing to onboard are left out.
+func (oc *OrgContents) Filter(f Filters) *OrgContents {
+	if f.IsEmpty() {
+		return oc
+	}
+
+	filtered := &OrgContents{Organizations: make([]Organization, 0)}
+	for _, o := range oc.Organizations {
+		if fo, ok := filterOrganization(o, []string{}, &f); ok {
+			filtered.Organizations = append(filtered.Organizations, fo)
+		}
+	}
+	return filtered
+}
+
+func filterOrganization(o Organization, parents []string, f *Filters) (Organization, bool) {
+	orgPath := append(parents[:len(parents):len(parents)], o.Name)
+	if f.ExcludesOrganization(orgPath) {
+		return o, false
+	}
+
+	filtered := o
+	filtered.Applications = make([]Application, 0)
+	filtered.SubOrganizations = make([]Organization, 0)
+	for _, a := range o.Applications {
+		if f.IncludesApplication(orgPath, a) {
+			filtered.Applications = append(filtered.Applications, a)
+		}
+	}
+	filtered.SkippedApplications = make([]SkippedApplication, 0)
+	for _, a := range o.SkippedApplications {
+		if f.IncludesApplication(orgPath, a.Application) {
+			filtered.SkippedApplications = append(filtered.SkippedApplications, a)
+		}
+	}
+	for _, so := range o.SubOrganizations {
+		if fso, ok := filterOrganization(so, orgPath, f); ok {
+			filtered.SubOrganizations = append(filtered.SubOrganizations, fso)
+		}
+	}
+	return filtered, len(filtered.Applications) > 0 || len(filtered.SubOrganizations) > 0
+}
+
+func (r *FilterRule) validate(label string) []string {
+	patterns := []struct{ field, pattern string }{
+		{"account", r.Account},
+		{"project", r.Project},
+		{"organization", r.Organization},
+		{"repository", r.Repository},
+		{"repositoryUrl", r.RepositoryUrl},
+	}
+
+	problems := make([]string, 0)
+	empty := true
+	for _, p := range patterns {
+		if p.pattern == "" {
+			continue
+		}
+		empty = false
+		if _, err := compileFilterPattern(p.pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.%s: malformed pattern '%s': %v", label, p.field, p.pattern, err))
+		}
+	}
+	if empty {
+		problems = append(problems, fmt.Sprintf("%s: at least one of account, project, organization, repository or repositoryUrl must be set", label))
+	}
+	return problems
+}
+
+// matchesOrganization decides whether every Organization pattern of the rule matches the Organization
+// named by orgPath - and so everything within it.
+func (r *FilterRule) matchesOrganization(orgPath []string) bool {
+	if r.Account != "" && !filterMatch(r.Account, orgPath[0]) {
+		return false
+	}
+	if r.Project != "" && (len(orgPath) < 2 || !filterMatch(r.Project, orgPath[1])) {
+		return false
+	}
+	if r.Organization != "" {
+		matched := false
+		for _, name := range orgPath {
+			matched = matched || filterMatch(r.Organization, name)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// mayMatchWithin decides whether the rule could match anything within the Organization named by orgPath,
+// given that Organizations (and so Projects) beneath it are not yet known.
+func (r *FilterRule) mayMatchWithin(orgPath []string) bool {
+	if r.Account != "" && !filterMatch(r.Account, orgPath[0]) {
+		return false
+	}
+	if r.Project != "" && len(orgPath) >= 2 && !filterMatch(r.Project, orgPath[1]) {
+		return false
+	}
+	return true
+}
+
+func (r *FilterRule) matchesApplication(orgPath []string, a Application) bool {
+	if len(orgPath) == 0 || !r.matchesOrganization(orgPath) {
+		return false
+	}
+	if r.Repository != "" && !filterMatch(r.Repository, a.Name) {
+		return false
+	}
+	if r.RepositoryUrl != "" && !filterMatchUrl(r.RepositoryUrl, a.RepositoryUrl) {
+		return false
+	}
+	return true
+}
+
+func filterMatch(pattern string, name string) bool {
+	matcher, err := compileFilterPattern(pattern)
+	return err == nil && matcher(name)
+}
+
+// filterMatchUrl is filterMatch for Repository URLs, where a glob's `*` and `?` also match `/`.
+func filterMatchUrl(pattern string, url string) bool {
+	if strings.HasPrefix(pattern, FILTER_REGEX_PREFIX) {
+		return filterMatch(pattern, url)
+	}
+	return filterMatch(strings.ReplaceAll(pattern, "/", urlGlobSeparator), strings.ReplaceAll(url, "/", urlGlobSeparator))
+}
+
+// compileFilterPattern returns a function matching the whole of a name against a glob, or a regular
+// expression when prefixed `re:`. Compiled patterns are cached.
+func compileFilterPattern(pattern string) (func(string) bool, error) {
+	if cached, ok := compiledFilterPatterns.Load(pattern); ok {
+		return cached.(func(string) bool), nil
+	}
+
+	var matcher func(string) bool
+	if expr, ok := strings.CutPrefix(pattern, FILTER_REGEX_PREFIX); ok {
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", expr))
+		if err != nil {
+			return nil, err
+		}
+		matcher = re.MatchString
+	} else {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		matcher = func(name string) bool {
+			matched, _ := path.Match(pattern, name)
+			return matched
+		}
+	}
+
+	compiledFilterPatterns.Store(pattern, matcher)
+	return matcher, nil
+}
@@ -0,0 +1,113 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrgContentsFilter(t *testing.T) {
+	orgContents := OrgContents{
+		Organizations: []Organization{
+			{
+				Name: "acme",
+				Applications: []Application{
+					{Name: "widget", RepositoryUrl: "https://github.com/acme/widget"},
+					{Name: "widget-archive", RepositoryUrl: "https://github.com/acme/widget-archive"},
+				},
+				SubOrganizations: []Organization{
+					{
+						Name:         "sandbox-jo",
+						Applications: []Application{{Name: "scratch", RepositoryUrl: "https://github.com/acme/scratch"}},
+					},
+					{
+						Name:         "old",
+						Applications: []Application{{Name: "legacy-archive", RepositoryUrl: "https://github.com/acme/legacy-archive"}},
+					},
+				},
+			},
+			{
+				Name:         "personal",
+				Applications: []Application{{Name: "dotfiles", RepositoryUrl: "https://github.com/personal/dotfiles"}},
+			},
+		},
+	}
+
+	filtered := orgContents.Filter(Filters{
+		Include: []FilterRule{{RepositoryUrl: "https://github.com/acme/*"}},
+		Exclude: []FilterRule{{Repository: "*-archive"}, {Organization: "sandbox-*"}},
+	})
+
+	assert.Len(t, filtered.Organizations, 1)
+	acme := filtered.Organizations[0]
+	assert.Equal(t, "acme", acme.Name)
+	assert.Len(t, acme.Applications, 1)
+	assert.Equal(t, "widget", acme.Applications[0].Name)
+	assert.Empty(t, acme.SubOrganizations)
+
+	// The original is left untouched
+	assert.Len(t, orgContents.Organizations[0].Applications, 2)
+	assert.Same(t, &orgContents, orgContents.Filter(Filters{}))
+}
+
+func TestFiltersMatchOrganizationsAbove(t *testing.T) {
+	f := Filters{Include: []FilterRule{{Organization: "acme", Repository: "api*"}}}
+	assert.True(t, f.IncludesApplication([]string{"acme", "Backend"}, Application{Name: "api-gateway"}))
+	assert.False(t, f.IncludesApplication([]string{"other", "Backend"}, Application{Name: "api-gateway"}))
+	assert.False(t, f.IncludesApplication([]string{"acme"}, Application{Name: "web"}))
+}
+
+func TestFiltersExcludeOrganization(t *testing.T) {
+	f := Filters{
+		Include: []FilterRule{{Account: "acme", Repository: "api*"}, {Project: "re:team-[a-z]+"}},
+		Exclude: []FilterRule{{Project: "sandbox*"}},
+	}
+	assert.False(t, f.ExcludesOrganization([]string{"acme"}))
+	assert.False(t, f.ExcludesOrganization([]string{"personal"}), "a Project beneath may yet match")
+	assert.True(t, f.ExcludesOrganization([]string{"personal", "misc"}))
+	assert.False(t, f.ExcludesOrganization([]string{"personal", "team-web"}))
+	assert.True(t, f.ExcludesOrganization([]string{"acme", "sandbox-jo"}))
+
+	assert.True(t, f.IncludesApplication([]string{"acme", "web"}, Application{Name: "api-gateway"}))
+	assert.False(t, f.IncludesApplication([]string{"acme", "web"}, Application{Name: "site"}))
+	assert.True(t, f.IncludesApplication([]string{"personal", "team-web"}, Application{Name: "site"}))
+	assert.False(t, f.IncludesApplication([]string{"team-web"}, Application{Name: "site"}), "an Account is not a Project")
+}
+
+func TestFiltersMatchRepositoryUrl(t *testing.T) {
+	archive := Application{Name: "widget-archive", RepositoryUrl: "https://github.com/acme/widget-archive"}
+	widget := Application{Name: "widget", RepositoryUrl: "https://github.com/acme/widget"}
+	nested := Application{Name: "api", RepositoryUrl: "https://gitlab.com/acme/backend/api"}
+
+	f := Filters{Exclude: []FilterRule{{RepositoryUrl: "*-archive"}}}
+	assert.False(t, f.IncludesApplication([]string{"acme"}, archive))
+	assert.True(t, f.IncludesApplication([]string{"acme"}, widget))
+
+	f = Filters{Include: []FilterRule{{RepositoryUrl: "https://gitlab.com/acme/*"}}}
+	assert.True(t, f.IncludesApplication([]string{"acme"}, nested))
+	assert.False(t, f.IncludesApplication([]string{"acme"}, widget))
+
+	f = Filters{Include: []FilterRule{{RepositoryUrl: "re:.*/acme/widget(-archive)?"}}}
+	assert.True(t, f.IncludesApplication([]string{"acme"}, archive))
+	assert.False(t, f.IncludesApplication([]string{"acme"}, nested))
+
+	// Names are still matched segment by segment
+	f = Filters{Include: []FilterRule{{Repository: "*-archive"}}}
+	assert.False(t, f.IncludesApplication([]string{"acme"}, Application{Name: "old/widget-archive"}))
+}
@@ -0,0 +1,209 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
+const (
+	DEFAULT_GITHUB_BASE_URL = "https://api.github.com"
+	GITHUB_PAGE_SIZE        = 100
+)
+
+type gitHubOrganization struct {
+	Login string `json:"login"`
+}
+
+type gitHubRepository struct {
+	Id            int64  `json:"id"`
+	Name          string `json:"name"`
+	DefaultBranch string `json:"default_branch"`
+	HtmlUrl       string `json:"html_url"`
+}
+
+type GitHubScmIntegration struct {
+	BaseUrl string
+	// Filters decide which Organizations and Repositories are loaded
+	Filters    Filters
+	token      string
+	httpClient *http.Client
+}
+
+// NewGitHubScmIntegration creates an integration for github.com or, when baseUrl is supplied,
+// a GitHub Enterprise Server API (e.g. https://github.example.com/api/v3).
+func NewGitHubScmIntegration(token string, baseUrl *string) *GitHubScmIntegration {
+	scm := &GitHubScmIntegration{
+		token:      token,
+		httpClient: util.NewRetryingHttpClient(util.DefaultRetryPolicy),
+	}
+	if baseUrl == nil {
+		scm.BaseUrl = DEFAULT_GITHUB_BASE_URL
+	} else {
+		scm.BaseUrl = strings.TrimRight(*baseUrl, "/")
+	}
+
+	return scm
+}
+
+func (scm *GitHubScmIntegration) GetMappedAsOrgContents() (*OrgContents, error) {
+	orgContents := OrgContents{}
+
+	githubOrgs, err := scm.getOrganizations()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, githubOrg := range *githubOrgs {
+		if scm.Filters.ExcludesOrganization([]string{githubOrg.Login}) {
+			log.Debug(fmt.Sprintf("GitHub - Organization %s excluded by filters", githubOrg.Login))
+			continue
+		}
+
+		apps, err := scm.getApplicationsForOrganization(&githubOrg)
+		if err != nil {
+			return nil, err
+		}
+
+		org := Organization{
+			Name:                  githubOrg.Login,
+			ScmProvider:           SCM_TYPE_GITHUB,
+			ApplyScmConfiguration: true,
+			Applications:          *apps,
+		}
+
+		orgContents.Organizations = append(orgContents.Organizations, org)
+	}
+
+	return orgContents.Filter(scm.Filters), nil
+}
+
+func (scm *GitHubScmIntegration) GetScmConfig() *ScmConfiguration {
+	return &ScmConfiguration{
+		Password: scm.token,
+		Type:     SCM_TYPE_GITHUB,
+	}
+}
+
+func (scm *GitHubScmIntegration) getApplicationsForOrganization(org *gitHubOrganization) (*[]Application, error) {
+	repos, err := scm.getRepositoriesForOrganization(org)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := make([]Application, 0)
+	for _, repo := range *repos {
+		appDto := Application{
+			Name:          repo.Name,
+			RepositoryUrl: repo.HtmlUrl,
+		}
+		if repo.Id != 0 {
+			appDto.ScmId = numericScmId(SCM_TYPE_GITHUB, repo.HtmlUrl, repo.Id)
+		}
+		if repo.DefaultBranch != "" {
+			defaultBranch := repo.DefaultBranch
+			appDto.DefaultBranch = &defaultBranch
+		}
+		apps = append(apps, appDto)
+	}
+
+	return &apps, nil
+}
+
+func (scm *GitHubScmIntegration) getOrganizations() (*[]gitHubOrganization, error) {
+	log.Debug("GitHub - Loading Organizations")
+
+	allOrgs := make([]gitHubOrganization, 0)
+	nextUrl := fmt.Sprintf("%s/user/orgs?per_page=%d", scm.BaseUrl, GITHUB_PAGE_SIZE)
+	for nextUrl != "" {
+		var page []gitHubOrganization
+		resp, err := getJson(scm.httpClient, nextUrl, scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		allOrgs = append(allOrgs, page...)
+		nextUrl = nextPageFromLinkHeader(resp)
+	}
+
+	log.Debug(fmt.Sprintf("Found %d GitHub Organizations", len(allOrgs)))
+	return &allOrgs, nil
+}
+
+func (scm *GitHubScmIntegration) getRepositoriesForOrganization(org *gitHubOrganization) (*[]gitHubRepository, error) {
+	log.Debug(fmt.Sprintf("Getting Repositories for GitHub Organization %s", org.Login))
+
+	allRepos := make([]gitHubRepository, 0)
+	nextUrl := fmt.Sprintf("%s/orgs/%s/repos?type=all&per_page=%d", scm.BaseUrl, url.PathEscape(org.Login), GITHUB_PAGE_SIZE)
+	for nextUrl != "" {
+		var page []gitHubRepository
+		resp, err := getJson(scm.httpClient, nextUrl, scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		allRepos = append(allRepos, page...)
+		nextUrl = nextPageFromLinkHeader(resp)
+	}
+
+	log.Debug(fmt.Sprintf("Found %d Repositories in GitHub Organization %s", len(allRepos), org.Login))
+	return &allRepos, nil
+}
+
+func (scm *GitHubScmIntegration) headers() map[string]string {
+	return map[string]string{
+		"Accept":               "application/vnd.github+json",
+		"Authorization":        fmt.Sprintf("Bearer %s", scm.token),
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+}
+
+// ValidateConnection checks the token can authenticate, list its Organizations and read the Repositories of each.
+func (scm *GitHubScmIntegration) ValidateConnection() *util.Checklist {
+	checks := util.NewChecklist()
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	_, err := getJson(scm.httpClient, fmt.Sprintf("%s/user", scm.BaseUrl), scm.headers(), &user)
+	if !checks.Check("Authenticate to GitHub", fmt.Sprintf("as %s", user.Login), err) {
+		return checks
+	}
+	log.Debug(fmt.Sprintf("Successfully connected to GitHub as %s", user.Login))
+
+	orgs, err := scm.getOrganizations()
+	if err != nil {
+		checks.Check("List GitHub Organizations", "", err)
+		return checks
+	}
+	names := make([]string, 0, len(*orgs))
+	for _, org := range *orgs {
+		names = append(names, org.Login)
+	}
+	checks.Check("List GitHub Organizations", describeNames("Organizations", names), nil)
+
+	for _, org := range *orgs {
+		var repos []gitHubRepository
+		_, err := getJson(scm.httpClient, fmt.Sprintf("%s/orgs/%s/repos?type=all&per_page=1", scm.BaseUrl, url.PathEscape(org.Login)), scm.headers(), &repos)
+		checks.Check(fmt.Sprintf("Read Repositories in %s", org.Login), "", err)
+	}
+	return checks
+}
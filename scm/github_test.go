@@ -0,0 +1,95 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitHubMappedAsOrgContents(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/user/orgs":
+			fmt.Fprint(w, `[{"login":"acme"}]`)
+		case "/orgs/acme/repos":
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `[{"name":"empty","default_branch":"","html_url":"https://github.example.com/acme/empty"}]`)
+				return
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<%s/orgs/acme/repos?page=2>; rel="next", <%s/orgs/acme/repos?page=2>; rel="last"`, server.URL, server.URL))
+			fmt.Fprint(w, `[{"id":1296269,"name":"widget","default_branch":"main","html_url":"https://github.example.com/acme/widget"}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	baseUrl := server.URL + "/"
+	orgContents, err := NewGitHubScmIntegration("secret", &baseUrl).GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	assert.Len(t, orgContents.Organizations, 1)
+
+	org := orgContents.Organizations[0]
+	assert.Equal(t, "acme", org.Name)
+	assert.Equal(t, SCM_TYPE_GITHUB, org.ScmProvider)
+	assert.Len(t, org.Applications, 2)
+	assert.Equal(t, "widget", org.Applications[0].Name)
+	assert.Equal(t, "main", *org.Applications[0].DefaultBranch)
+	assert.Equal(t, "https://github.example.com/acme/widget", org.Applications[0].RepositoryUrl)
+	assert.Equal(t, "github-github.example.com-1296269", org.Applications[0].ScmId)
+	assert.Equal(t, "repo-github-github.example.com-1296269", org.Applications[0].StableId())
+	assert.Nil(t, org.Applications[1].DefaultBranch)
+}
+
+func TestGitHubValidateConnection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user":
+			fmt.Fprint(w, `{"login":"jo"}`)
+		case "/user/orgs":
+			fmt.Fprint(w, `[{"login":"acme"},{"login":"secret-project"}]`)
+		case "/orgs/acme/repos":
+			fmt.Fprint(w, `[]`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	baseUrl := server.URL
+	checks := NewGitHubScmIntegration("secret", &baseUrl).ValidateConnection()
+	assert.False(t, checks.Passed())
+	assert.Len(t, checks.Checks, 4)
+	assert.Equal(t, "as jo", checks.Checks[0].Detail)
+	assert.Equal(t, "2 Organizations: acme, secret-project", checks.Checks[1].Detail)
+	assert.True(t, checks.Checks[2].Passed)
+	assert.Equal(t, "Read Repositories in secret-project", checks.Checks[3].Description)
+	assert.False(t, checks.Checks[3].Passed)
+}
+
+func TestGitHubScmConfig(t *testing.T) {
+	scmConfig := NewGitHubScmIntegration("secret", nil).GetScmConfig()
+	assert.Equal(t, SCM_TYPE_GITHUB, scmConfig.Type)
+	assert.Equal(t, "secret", scmConfig.Password)
+}
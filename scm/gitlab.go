@@ -0,0 +1,256 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
+const (
+	DEFAULT_GITLAB_BASE_URL = "https://gitlab.com/api/v4"
+	GITLAB_PAGE_SIZE        = 100
+)
+
+type gitLabGroup struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	FullPath string `json:"full_path"`
+}
+
+type gitLabProject struct {
+	Id            int    `json:"id"`
+	Name          string `json:"name"`
+	DefaultBranch string `json:"default_branch"`
+	WebUrl        string `json:"web_url"`
+	Archived      bool   `json:"archived"`
+}
+
+type GitLabScmIntegration struct {
+	BaseUrl         string
+	IncludeArchived bool
+	// Filters decide which Groups and Projects are loaded - Projects and Subgroups of excluded Groups are
+	// never requested
+	Filters    Filters
+	token      string
+	httpClient *http.Client
+}
+
+// NewGitLabScmIntegration creates an integration for gitlab.com or, when baseUrl is supplied,
+// a self-managed GitLab API (e.g. https://gitlab.example.com/api/v4).
+func NewGitLabScmIntegration(token string, baseUrl *string) *GitLabScmIntegration {
+	scm := &GitLabScmIntegration{
+		token:      token,
+		httpClient: util.NewRetryingHttpClient(util.DefaultRetryPolicy),
+	}
+	if baseUrl == nil {
+		scm.BaseUrl = DEFAULT_GITLAB_BASE_URL
+	} else {
+		scm.BaseUrl = strings.TrimRight(*baseUrl, "/")
+	}
+
+	return scm
+}
+
+func (scm *GitLabScmIntegration) GetMappedAsOrgContents() (*OrgContents, error) {
+	orgContents := OrgContents{}
+
+	groups, err := scm.getTopLevelGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range *groups {
+		if scm.Filters.ExcludesOrganization([]string{group.Name}) {
+			log.Debug(fmt.Sprintf("GitLab - Group %s excluded by filters", group.FullPath))
+			continue
+		}
+
+		org, err := scm.getOrganizationForGroup(&group, []string{group.Name})
+		if err != nil {
+			return nil, err
+		}
+		org.ApplyScmConfiguration = true
+		orgContents.Organizations = append(orgContents.Organizations, *org)
+	}
+
+	return orgContents.Filter(scm.Filters), nil
+}
+
+func (scm *GitLabScmIntegration) GetScmConfig() *ScmConfiguration {
+	return &ScmConfiguration{
+		Password: scm.token,
+		Type:     SCM_TYPE_GITLAB,
+	}
+}
+
+// getOrganizationForGroup maps a GitLab Group, and recursively all of its Subgroups, into an Organization.
+// orgPath names the Group and those above it, outermost first.
+func (scm *GitLabScmIntegration) getOrganizationForGroup(group *gitLabGroup, orgPath []string) (*Organization, error) {
+	apps, err := scm.getApplicationsForGroup(group)
+	if err != nil {
+		return nil, err
+	}
+
+	subGroups, err := scm.getSubGroups(group)
+	if err != nil {
+		return nil, err
+	}
+
+	subOrgs := make([]Organization, 0)
+	for _, subGroup := range *subGroups {
+		subOrgPath := append(orgPath[:len(orgPath):len(orgPath)], subGroup.Name)
+		if scm.Filters.ExcludesOrganization(subOrgPath) {
+			log.Debug(fmt.Sprintf("GitLab - Group %s excluded by filters", subGroup.FullPath))
+			continue
+		}
+
+		subOrg, err := scm.getOrganizationForGroup(&subGroup, subOrgPath)
+		if err != nil {
+			return nil, err
+		}
+		subOrgs = append(subOrgs, *subOrg)
+	}
+
+	return &Organization{
+		Name:             group.Name,
+		ScmProvider:      SCM_TYPE_GITLAB,
+		Applications:     *apps,
+		SubOrganizations: subOrgs,
+	}, nil
+}
+
+func (scm *GitLabScmIntegration) getApplicationsForGroup(group *gitLabGroup) (*[]Application, error) {
+	projects, err := scm.getProjectsForGroup(group)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := make([]Application, 0)
+	for _, project := range *projects {
+		if project.Archived && !scm.IncludeArchived {
+			log.Debug(fmt.Sprintf("Skipping archived GitLab Project %s/%s", group.FullPath, project.Name))
+			continue
+		}
+
+		appDto := Application{
+			Name:          project.Name,
+			RepositoryUrl: project.WebUrl,
+		}
+		if project.Id != 0 {
+			appDto.ScmId = numericScmId(SCM_TYPE_GITLAB, project.WebUrl, int64(project.Id))
+		}
+		if project.DefaultBranch != "" {
+			defaultBranch := project.DefaultBranch
+			appDto.DefaultBranch = &defaultBranch
+		}
+		apps = append(apps, appDto)
+	}
+
+	return &apps, nil
+}
+
+func (scm *GitLabScmIntegration) getTopLevelGroups() (*[]gitLabGroup, error) {
+	log.Debug("GitLab - Loading top level Groups")
+	return scm.getGroups(fmt.Sprintf("%s/groups?top_level_only=true&min_access_level=10&per_page=%d", scm.BaseUrl, GITLAB_PAGE_SIZE))
+}
+
+func (scm *GitLabScmIntegration) getSubGroups(group *gitLabGroup) (*[]gitLabGroup, error) {
+	log.Debug(fmt.Sprintf("GitLab - Loading Subgroups of %s", group.FullPath))
+	return scm.getGroups(fmt.Sprintf("%s/groups/%d/subgroups?per_page=%d", scm.BaseUrl, group.Id, GITLAB_PAGE_SIZE))
+}
+
+func (scm *GitLabScmIntegration) getGroups(firstPageUrl string) (*[]gitLabGroup, error) {
+	allGroups := make([]gitLabGroup, 0)
+	nextUrl := firstPageUrl
+	for nextUrl != "" {
+		var page []gitLabGroup
+		resp, err := getJson(scm.httpClient, nextUrl, scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		allGroups = append(allGroups, page...)
+		nextUrl = nextPageFromLinkHeader(resp)
+	}
+
+	return &allGroups, nil
+}
+
+func (scm *GitLabScmIntegration) getProjectsForGroup(group *gitLabGroup) (*[]gitLabProject, error) {
+	log.Debug(fmt.Sprintf("Getting Projects for GitLab Group %s", group.FullPath))
+
+	nextUrl := fmt.Sprintf("%s/groups/%d/projects?include_subgroups=false&with_shared=false&per_page=%d", scm.BaseUrl, group.Id, GITLAB_PAGE_SIZE)
+	if !scm.IncludeArchived {
+		nextUrl = nextUrl + "&archived=false"
+	}
+
+	allProjects := make([]gitLabProject, 0)
+	for nextUrl != "" {
+		var page []gitLabProject
+		resp, err := getJson(scm.httpClient, nextUrl, scm.headers(), &page)
+		if err != nil {
+			return nil, err
+		}
+		allProjects = append(allProjects, page...)
+		nextUrl = nextPageFromLinkHeader(resp)
+	}
+
+	log.Debug(fmt.Sprintf("Found %d Projects in GitLab Group %s", len(allProjects), group.FullPath))
+	return &allProjects, nil
+}
+
+func (scm *GitLabScmIntegration) headers() map[string]string {
+	return map[string]string{
+		"PRIVATE-TOKEN": scm.token,
+	}
+}
+
+// ValidateConnection checks the token can authenticate, list its top level Groups and read the Projects of each.
+func (scm *GitLabScmIntegration) ValidateConnection() *util.Checklist {
+	checks := util.NewChecklist()
+
+	var user struct {
+		Username string `json:"username"`
+	}
+	_, err := getJson(scm.httpClient, fmt.Sprintf("%s/user", scm.BaseUrl), scm.headers(), &user)
+	if !checks.Check("Authenticate to GitLab", fmt.Sprintf("as %s", user.Username), err) {
+		return checks
+	}
+	log.Debug(fmt.Sprintf("Successfully connected to GitLab as %s", user.Username))
+
+	groups, err := scm.getTopLevelGroups()
+	if err != nil {
+		checks.Check("List GitLab Groups", "", err)
+		return checks
+	}
+	names := make([]string, 0, len(*groups))
+	for _, group := range *groups {
+		names = append(names, group.FullPath)
+	}
+	checks.Check("List GitLab Groups", describeNames("Groups", names), nil)
+
+	for _, group := range *groups {
+		var projects []gitLabProject
+		_, err := getJson(scm.httpClient, fmt.Sprintf("%s/groups/%d/projects?include_subgroups=false&per_page=1", scm.BaseUrl, group.Id), scm.headers(), &projects)
+		checks.Check(fmt.Sprintf("Read Projects in %s", group.FullPath), "", err)
+	}
+	return checks
+}
@@ -0,0 +1,99 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newGitLabTestServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("PRIVATE-TOKEN"))
+		switch r.URL.Path {
+		case "/groups":
+			fmt.Fprint(w, `[{"id":1,"name":"Platform","full_path":"platform"}]`)
+		case "/groups/1/subgroups":
+			fmt.Fprint(w, `[{"id":2,"name":"Backend","full_path":"platform/backend"}]`)
+		case "/groups/2/subgroups":
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `[{"id":4,"name":"Jobs","full_path":"platform/backend/jobs"}]`)
+				return
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<%s/groups/2/subgroups?page=2>; rel="next"`, server.URL))
+			fmt.Fprint(w, `[{"id":3,"name":"Services","full_path":"platform/backend/services"}]`)
+		case "/groups/3/subgroups", "/groups/4/subgroups":
+			fmt.Fprint(w, `[]`)
+		case "/groups/1/projects", "/groups/2/projects", "/groups/4/projects":
+			fmt.Fprint(w, `[]`)
+		case "/groups/3/projects":
+			if r.URL.Query().Get("archived") == "false" {
+				fmt.Fprint(w, `[{"id":10,"name":"api","default_branch":"main","web_url":"https://gitlab.example.com/platform/backend/services/api"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"id":10,"name":"api","default_branch":"main","web_url":"https://gitlab.example.com/platform/backend/services/api"},{"id":11,"name":"legacy","default_branch":"master","web_url":"https://gitlab.example.com/platform/backend/services/legacy","archived":true}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestGitLabMappedAsOrgContents(t *testing.T) {
+	server := newGitLabTestServer(t)
+	defer server.Close()
+
+	orgContents, err := NewGitLabScmIntegration("secret", &server.URL).GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	assert.Len(t, orgContents.Organizations, 1)
+
+	platform := orgContents.Organizations[0]
+	assert.Equal(t, "Platform", platform.Name)
+	assert.Len(t, platform.SubOrganizations, 1)
+
+	backend := platform.SubOrganizations[0]
+	assert.Equal(t, "Backend", backend.Name)
+	assert.Len(t, backend.SubOrganizations, 2)
+
+	services := backend.SubOrganizations[0]
+	assert.Equal(t, SCM_TYPE_GITLAB, services.ScmProvider)
+	assert.Len(t, services.Applications, 1)
+	assert.Equal(t, "api", services.Applications[0].Name)
+	assert.Equal(t, "main", *services.Applications[0].DefaultBranch)
+	assert.Equal(t, "gitlab-gitlab.example.com-10", services.Applications[0].ScmId)
+	assert.Equal(t, "repo-gitlab-gitlab.example.com-10", services.Applications[0].StableId())
+	assert.Equal(t, "Jobs", backend.SubOrganizations[1].Name)
+}
+
+func TestGitLabIncludeArchived(t *testing.T) {
+	server := newGitLabTestServer(t)
+	defer server.Close()
+
+	gitlab := NewGitLabScmIntegration("secret", &server.URL)
+	gitlab.IncludeArchived = true
+	orgContents, err := gitlab.GetMappedAsOrgContents()
+	assert.NoError(t, err)
+
+	services := orgContents.Organizations[0].SubOrganizations[0].SubOrganizations[0]
+	assert.Len(t, services.Applications, 2)
+	assert.Equal(t, "legacy", services.Applications[1].Name)
+}
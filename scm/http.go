@@ -0,0 +1,71 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	LINK_HEADER_NEXT = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+)
+
+// getJson performs a GET against the supplied URL, applying the supplied headers, and decodes
+// a successful JSON response into out.
+func getJson(client *http.Client, url string, headers map[string]string, out interface{}) (*http.Response, error) {
+	log.Debug(fmt.Sprintf("GET %s", url))
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return resp, fmt.Errorf("GET %s returned %s: %s", url, resp.Status, string(b))
+	}
+
+	return resp, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// nextPageFromLinkHeader returns the URL of the next page as advertised in an RFC 8288 Link
+// header, or an empty string if there is no next page.
+func nextPageFromLinkHeader(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+	matches := LINK_HEADER_NEXT.FindStringSubmatch(resp.Header.Get("Link"))
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1]
+}
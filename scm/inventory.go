@@ -0,0 +1,336 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	INVENTORY_FORMAT_CSV  = "csv"
+	INVENTORY_FORMAT_JSON = "json"
+	INVENTORY_FORMAT_YAML = "yaml"
+	// INVENTORY_PATH_SEPARATOR joins the names of nested Organizations in the organizationPath CSV column
+	INVENTORY_PATH_SEPARATOR = "/"
+)
+
+var (
+	INVENTORY_FORMATS    = []string{INVENTORY_FORMAT_CSV, INVENTORY_FORMAT_JSON, INVENTORY_FORMAT_YAML}
+	INVENTORY_CSV_HEADER = []string{
+		"organizationPath", "organizationSafeName", "scmProvider", "applicationName", "applicationSafeName", "applicationSafeId",
+		"scmId", "repositoryUrl", "defaultBranch", "repositoryUrlPermitted", "branchNamePermitted", "skippedReason",
+	}
+)
+
+/**
+ * Inventory is OrgContents as written by Export - with the names and IDs each Organization and Application
+ * would be created with in Sonatype Lifecycle, and whether its source control configuration can be set.
+ */
+type Inventory struct {
+	Organizations []InventoryOrganization `json:"organizations" yaml:"organizations"`
+}
+
+type InventoryOrganization struct {
+	Name                  string                 `json:"name" yaml:"name"`
+	SafeName              string                 `json:"safeName,omitempty" yaml:"safeName,omitempty"`
+	ScmProvider           string                 `json:"scmProvider,omitempty" yaml:"scmProvider,omitempty"`
+	ApplyScmConfiguration bool                   `json:"applyScmConfiguration,omitempty" yaml:"applyScmConfiguration,omitempty"`
+	Applications          []InventoryApplication `json:"applications,omitempty" yaml:"applications,omitempty"`
+	// SkippedApplications are Repositories the SCM integration left out, with the reason
+	SkippedApplications []InventoryApplication  `json:"skippedApplications,omitempty" yaml:"skippedApplications,omitempty"`
+	Organizations       []InventoryOrganization `json:"organizations,omitempty" yaml:"organizations,omitempty"`
+}
+
+type InventoryApplication struct {
+	Name                   string `json:"name" yaml:"name"`
+	SafeName               string `json:"safeName,omitempty" yaml:"safeName,omitempty"`
+	SafeId                 string `json:"safeId,omitempty" yaml:"safeId,omitempty"`
+	ScmId                  string `json:"scmId,omitempty" yaml:"scmId,omitempty"`
+	RepositoryUrl          string `json:"repositoryUrl" yaml:"repositoryUrl"`
+	DefaultBranch          string `json:"defaultBranch,omitempty" yaml:"defaultBranch,omitempty"`
+	RepositoryUrlPermitted bool   `json:"repositoryUrlPermitted" yaml:"repositoryUrlPermitted"`
+	BranchNamePermitted    bool   `json:"branchNamePermitted" yaml:"branchNamePermitted"`
+	SkippedReason          string `json:"skippedReason,omitempty" yaml:"skippedReason,omitempty"`
+}
+
+// Inventory describes the OrgContents, including the names and IDs they would be created with.
+func (oc *OrgContents) Inventory() *Inventory {
+	inventory := &Inventory{Organizations: make([]InventoryOrganization, 0, len(oc.Organizations))}
+	for _, o := range oc.Organizations {
+		inventory.Organizations = append(inventory.Organizations, inventoryOrganization(o))
+	}
+	return inventory
+}
+
+// Export writes the Inventory of the OrgContents in the given format - CSV has a row per Application.
+func (oc *OrgContents) Export(w io.Writer, format string) error {
+	inventory := oc.Inventory()
+	switch format {
+	case INVENTORY_FORMAT_CSV:
+		return inventory.writeCsv(w)
+	case INVENTORY_FORMAT_JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(inventory)
+	case INVENTORY_FORMAT_YAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		err := encoder.Encode(inventory)
+		if err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+	return fmt.Errorf("unsupported format '%s' - must be one of %s", format, strings.Join(INVENTORY_FORMATS, ", "))
+}
+
+/**
+ * ReadInventory reads an Inventory in the given format, as written by Export or by hand. Only the
+ * organizationPath, applicationName, repositoryUrl, scmId, defaultBranch, scmProvider and skippedReason columns
+ * of a CSV are read - any others (such as the safe names written by Export) are ignored.
+ */
+func ReadInventory(r io.Reader, format string) (*Inventory, error) {
+	inventory := &Inventory{}
+	var err error
+	switch format {
+	case INVENTORY_FORMAT_CSV:
+		return readInventoryCsv(r)
+	case INVENTORY_FORMAT_JSON:
+		decoder := json.NewDecoder(r)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(inventory)
+	case INVENTORY_FORMAT_YAML:
+		decoder := yaml.NewDecoder(r)
+		decoder.KnownFields(true)
+		err = decoder.Decode(inventory)
+	default:
+		return nil, fmt.Errorf("unsupported format '%s' - must be one of %s", format, strings.Join(INVENTORY_FORMATS, ", "))
+	}
+	if err == io.EOF {
+		return nil, errors.New("it is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
+
+// InventoryFormatForPath is the format of an Inventory file judged by its extension - YAML unless .csv or .json.
+func InventoryFormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return INVENTORY_FORMAT_CSV
+	case ".json":
+		return INVENTORY_FORMAT_JSON
+	}
+	return INVENTORY_FORMAT_YAML
+}
+
+func inventoryOrganization(o Organization) InventoryOrganization {
+	org := InventoryOrganization{
+		Name:                  o.Name,
+		SafeName:              o.SafeName(),
+		ScmProvider:           o.ScmProvider,
+		ApplyScmConfiguration: o.ApplyScmConfiguration,
+	}
+	for _, a := range o.Applications {
+		org.Applications = append(org.Applications, inventoryApplication(a, ""))
+	}
+	for _, a := range o.SkippedApplications {
+		org.SkippedApplications = append(org.SkippedApplications, inventoryApplication(a.Application, a.Reason))
+	}
+	for _, so := range o.SubOrganizations {
+		org.Organizations = append(org.Organizations, inventoryOrganization(so))
+	}
+	return org
+}
+
+func inventoryApplication(a Application, skippedReason string) InventoryApplication {
+	ia := InventoryApplication{
+		Name:                   a.Name,
+		SafeName:               a.SafeName(),
+		SafeId:                 a.SafeId(),
+		ScmId:                  a.ScmId,
+		RepositoryUrl:          a.RepositoryUrl,
+		RepositoryUrlPermitted: a.IsRepositoryUrlPermitted(),
+		BranchNamePermitted:    a.IsBranchNamePermitted(),
+		SkippedReason:          skippedReason,
+	}
+	if a.DefaultBranch != nil {
+		ia.DefaultBranch = *a.DefaultBranch
+	}
+	return ia
+}
+
+// writeCsv writes a row per Application - and one for each Organization without any, so none are lost.
+func (inv *Inventory) writeCsv(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(INVENTORY_CSV_HEADER)
+	if err != nil {
+		return err
+	}
+
+	var write func(o InventoryOrganization, parents []string) error
+	write = func(o InventoryOrganization, parents []string) error {
+		orgPath := append(parents[:len(parents):len(parents)], o.Name)
+		organizationColumns := []string{strings.Join(orgPath, INVENTORY_PATH_SEPARATOR), o.SafeName, o.ScmProvider}
+		applications := append(append([]InventoryApplication{}, o.Applications...), o.SkippedApplications...)
+		if len(applications) == 0 {
+			err := writer.Write(append(organizationColumns, make([]string, len(INVENTORY_CSV_HEADER)-len(organizationColumns))...))
+			if err != nil {
+				return err
+			}
+		}
+		for _, a := range applications {
+			err := writer.Write(append(organizationColumns,
+				a.Name, a.SafeName, a.SafeId, a.ScmId, a.RepositoryUrl, a.DefaultBranch,
+				strconv.FormatBool(a.RepositoryUrlPermitted), strconv.FormatBool(a.BranchNamePermitted), a.SkippedReason,
+			))
+			if err != nil {
+				return err
+			}
+		}
+		for _, so := range o.Organizations {
+			if err := write(so, orgPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, o := range inv.Organizations {
+		if err := write(o, []string{}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// inventoryCsvOrganization is an Organization being assembled from CSV rows, which may be in any order.
+type inventoryCsvOrganization struct {
+	InventoryOrganization
+	children []*inventoryCsvOrganization
+	byName   map[string]*inventoryCsvOrganization
+}
+
+func (o *inventoryCsvOrganization) child(name string) *inventoryCsvOrganization {
+	if c, ok := o.byName[name]; ok {
+		return c
+	}
+	c := &inventoryCsvOrganization{InventoryOrganization: InventoryOrganization{Name: name}, byName: make(map[string]*inventoryCsvOrganization)}
+	o.byName[name] = c
+	o.children = append(o.children, c)
+	return c
+}
+
+func (o *inventoryCsvOrganization) build() InventoryOrganization {
+	built := o.InventoryOrganization
+	for _, c := range o.children {
+		built.Organizations = append(built.Organizations, c.build())
+	}
+	return built
+}
+
+func readInventoryCsv(r io.Reader) (*Inventory, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("it is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"organizationPath", "applicationName", "repositoryUrl"} {
+		if _, ok := columns[strings.ToLower(required)]; !ok {
+			return nil, fmt.Errorf("the %s column is missing", required)
+		}
+	}
+
+	root := &inventoryCsvOrganization{byName: make(map[string]*inventoryCsvOrganization)}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		value := func(column string) string {
+			i, ok := columns[strings.ToLower(column)]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		orgPath := value("organizationPath")
+		if orgPath == "" {
+			return nil, fmt.Errorf("line %d: organizationPath must be set", line)
+		}
+		org := root
+		provider := value("scmProvider")
+		for _, name := range strings.Split(orgPath, INVENTORY_PATH_SEPARATOR) {
+			org = org.child(strings.TrimSpace(name))
+			if org.ScmProvider == "" {
+				org.ScmProvider = provider
+			} else if provider != "" && org.ScmProvider != provider {
+				return nil, fmt.Errorf("line %d: scmProvider '%s' differs from '%s' given for %s before", line, provider, org.ScmProvider, org.Name)
+			}
+		}
+
+		if value("applicationName") == "" && value("repositoryUrl") == "" {
+			continue
+		}
+		a := InventoryApplication{
+			Name:          value("applicationName"),
+			RepositoryUrl: value("repositoryUrl"),
+			ScmId:         value("scmId"),
+			DefaultBranch: value("defaultBranch"),
+			SkippedReason: value("skippedReason"),
+		}
+		if a.SkippedReason != "" {
+			org.SkippedApplications = append(org.SkippedApplications, a)
+		} else {
+			org.Applications = append(org.Applications, a)
+		}
+	}
+
+	inventory := &Inventory{Organizations: make([]InventoryOrganization, 0, len(root.children))}
+	for _, o := range root.children {
+		// SCM configuration is set on the top level Organizations, as it is when loading from an SCM
+		o.ApplyScmConfiguration = o.ScmProvider != ""
+		inventory.Organizations = append(inventory.Organizations, o.build())
+	}
+	return inventory, nil
+}
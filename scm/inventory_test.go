@@ -0,0 +1,100 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func inventoryTestContents() *OrgContents {
+	main := "main"
+	return &OrgContents{
+		Organizations: []Organization{
+			{
+				Name:                  "acme",
+				ScmProvider:           SCM_TYPE_AZURE,
+				ApplyScmConfiguration: true,
+				SubOrganizations: []Organization{
+					{
+						Name:         "Platform Team",
+						ScmProvider:  SCM_TYPE_AZURE,
+						Applications: []Application{{Name: "api gateway", DefaultBranch: &main, ScmId: "5febef5a-833d-4e14-b9c0-14cb638f91e6", RepositoryUrl: "https://dev.azure.com/acme/Platform%20Team/_git/api%20gateway"}},
+						SkippedApplications: []SkippedApplication{
+							{Application: Application{Name: "old", RepositoryUrl: "https://dev.azure.com/acme/Platform%20Team/_git/old(1)"}, Reason: "repository is disabled"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestExportYaml(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, inventoryTestContents().Export(&out, INVENTORY_FORMAT_YAML))
+
+	inventory := Inventory{}
+	assert.NoError(t, yaml.Unmarshal(out.Bytes(), &inventory))
+	acme := inventory.Organizations[0]
+	assert.Equal(t, "acme", acme.Name)
+	assert.True(t, acme.ApplyScmConfiguration)
+	assert.Empty(t, acme.Applications)
+
+	platform := acme.Organizations[0]
+	assert.Equal(t, "Platform Team", platform.SafeName)
+	app := platform.Applications[0]
+	assert.Equal(t, "api gateway", app.Name)
+	assert.Equal(t, "api-gateway", app.SafeId)
+	assert.Equal(t, "main", app.DefaultBranch)
+	assert.True(t, app.BranchNamePermitted)
+	assert.True(t, app.RepositoryUrlPermitted)
+	assert.False(t, platform.SkippedApplications[0].RepositoryUrlPermitted, "brackets are not permitted in a Repository URL")
+	assert.Equal(t, "repository is disabled", platform.SkippedApplications[0].SkippedReason)
+}
+
+func TestExportJson(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, inventoryTestContents().Export(&out, INVENTORY_FORMAT_JSON))
+
+	inventory := Inventory{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &inventory))
+	assert.Equal(t, "old", inventory.Organizations[0].Organizations[0].SkippedApplications[0].Name)
+}
+
+func TestExportCsv(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, inventoryTestContents().Export(&out, INVENTORY_FORMAT_CSV))
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		INVENTORY_CSV_HEADER,
+		{"acme", "acme", SCM_TYPE_AZURE, "", "", "", "", "", "", "", "", ""},
+		{"acme/Platform Team", "Platform Team", SCM_TYPE_AZURE, "api gateway", "api gateway", "api-gateway", "5febef5a-833d-4e14-b9c0-14cb638f91e6", "https://dev.azure.com/acme/Platform%20Team/_git/api%20gateway", "main", "true", "true", ""},
+		{"acme/Platform Team", "Platform Team", SCM_TYPE_AZURE, "old", "old", "old", "", "https://dev.azure.com/acme/Platform%20Team/_git/old(1)", "", "false", "false", "repository is disabled"},
+	}, rows)
+}
+
+func TestExportUnsupportedFormat(t *testing.T) {
+	assert.ErrorContains(t, inventoryTestContents().Export(&bytes.Buffer{}, "xml"), "must be one of csv, json, yaml")
+}
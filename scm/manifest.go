@@ -0,0 +1,194 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
+var MANIFEST_SCM_PROVIDERS = []string{SCM_TYPE_AZURE, SCM_TYPE_BITBUCKET, SCM_TYPE_GITHUB, SCM_TYPE_GITLAB}
+
+/**
+ * ManifestScmIntegration loads Organizations and Applications from a manifest file - an Inventory in
+ * YAML, JSON or CSV (judged by its extension), as written by the export command or by hand - so SCMs
+ * that are not supported, or a hand picked subset of one, can be onboarded.
+ */
+type ManifestScmIntegration struct {
+	Path string
+	// Username and Token are the credentials set as SCM configuration on the Organizations - none is set
+	// when there is no Token
+	Username string
+	Token    string
+	// Filters decide which Organizations and Repositories are loaded
+	Filters Filters
+	// scmProvider is that of the Organizations SCM configuration is set on, once the manifest is loaded
+	scmProvider string
+}
+
+func NewManifestScmIntegration(path string, token string) *ManifestScmIntegration {
+	return &ManifestScmIntegration{Path: path, Token: token}
+}
+
+func (scm *ManifestScmIntegration) GetMappedAsOrgContents() (*OrgContents, error) {
+	inventory, err := scm.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	orgContents := OrgContents{Organizations: make([]Organization, 0, len(inventory.Organizations))}
+	problems := make([]string, 0)
+	for _, mo := range inventory.Organizations {
+		org, orgProblems := manifestOrganization(mo, []string{}, "")
+		orgContents.Organizations = append(orgContents.Organizations, org)
+		problems = append(problems, orgProblems...)
+	}
+
+	providers := make([]string, 0)
+	for _, o := range orgContents.Organizations {
+		if o.ApplyScmConfiguration && !slices.Contains(providers, o.ScmProvider) {
+			providers = append(providers, o.ScmProvider)
+		}
+	}
+	if scm.Token != "" && len(providers) > 1 {
+		problems = append(problems, fmt.Sprintf("SCM configuration can only be set for one scmProvider but Organizations are for %s - use a manifest per scmProvider", strings.Join(providers, ", ")))
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("manifest %s is not valid:\n  - %s", scm.Path, strings.Join(problems, "\n  - "))
+	}
+	if len(providers) == 1 {
+		scm.scmProvider = providers[0]
+	}
+
+	organizations, applications := orgContents.Count()
+	log.Debug(fmt.Sprintf("Read %d Organizations and %d Applications from manifest %s", organizations, applications, scm.Path))
+	return orgContents.Filter(scm.Filters), nil
+}
+
+func (scm *ManifestScmIntegration) GetScmConfig() *ScmConfiguration {
+	if scm.Token == "" || scm.scmProvider == "" {
+		return nil
+	}
+	return &ScmConfiguration{
+		Type:     scm.scmProvider,
+		Username: scm.Username,
+		Password: scm.Token,
+	}
+}
+
+// ValidateConnection checks the manifest can be read and lists the top level Organizations in it.
+func (scm *ManifestScmIntegration) ValidateConnection() *util.Checklist {
+	checks := util.NewChecklist()
+
+	inventory, err := scm.readManifest()
+	if !checks.Check(fmt.Sprintf("Read manifest %s", scm.Path), "", err) {
+		return checks
+	}
+	names := make([]string, 0, len(inventory.Organizations))
+	for _, o := range inventory.Organizations {
+		names = append(names, o.Name)
+	}
+	checks.Check("List Organizations in manifest", describeNames("Organizations", names), nil)
+	return checks
+}
+
+func (scm *ManifestScmIntegration) readManifest() (*Inventory, error) {
+	f, err := os.Open(scm.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	defer f.Close()
+
+	inventory, err := ReadInventory(f, InventoryFormatForPath(scm.Path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %v", scm.Path, err)
+	}
+	if len(inventory.Organizations) == 0 {
+		return nil, fmt.Errorf("manifest %s holds no Organizations", scm.Path)
+	}
+	return inventory, nil
+}
+
+/**
+ * manifestOrganization maps an Organization read from a manifest, returning a description of each problem
+ * with it or anything within it. Organizations without a scmProvider take that of the one above them.
+ */
+func manifestOrganization(mo InventoryOrganization, parents []string, parentProvider string) (Organization, []string) {
+	orgPath := append(parents[:len(parents):len(parents)], mo.Name)
+	label := strings.Join(orgPath, INVENTORY_PATH_SEPARATOR)
+	problems := make([]string, 0)
+	if strings.TrimSpace(mo.Name) == "" {
+		problems = append(problems, fmt.Sprintf("%s: an Organization has no name", label))
+	}
+
+	org := Organization{
+		Name:                  mo.Name,
+		ScmProvider:           mo.ScmProvider,
+		ApplyScmConfiguration: mo.ApplyScmConfiguration,
+		Applications:          make([]Application, 0, len(mo.Applications)),
+		SkippedApplications:   make([]SkippedApplication, 0, len(mo.SkippedApplications)),
+		SubOrganizations:      make([]Organization, 0, len(mo.Organizations)),
+	}
+	if org.ScmProvider == "" {
+		org.ScmProvider = parentProvider
+	}
+	if org.ScmProvider != "" && !slices.Contains(MANIFEST_SCM_PROVIDERS, org.ScmProvider) {
+		problems = append(problems, fmt.Sprintf("%s: scmProvider '%s' must be one of %s", label, org.ScmProvider, strings.Join(MANIFEST_SCM_PROVIDERS, ", ")))
+	}
+	if org.ApplyScmConfiguration && org.ScmProvider == "" {
+		problems = append(problems, fmt.Sprintf("%s: scmProvider must be set to apply SCM configuration", label))
+	}
+
+	for _, ia := range mo.Applications {
+		a, appProblems := manifestApplication(ia, label)
+		org.Applications = append(org.Applications, a)
+		problems = append(problems, appProblems...)
+	}
+	for _, ia := range mo.SkippedApplications {
+		a, appProblems := manifestApplication(ia, label)
+		org.SkippedApplications = append(org.SkippedApplications, SkippedApplication{Application: a, Reason: ia.SkippedReason})
+		problems = append(problems, appProblems...)
+	}
+	for _, so := range mo.Organizations {
+		sub, subProblems := manifestOrganization(so, orgPath, org.ScmProvider)
+		org.SubOrganizations = append(org.SubOrganizations, sub)
+		problems = append(problems, subProblems...)
+	}
+	return org, problems
+}
+
+func manifestApplication(ia InventoryApplication, label string) (Application, []string) {
+	problems := make([]string, 0)
+	if strings.TrimSpace(ia.Name) == "" {
+		problems = append(problems, fmt.Sprintf("%s: the Application for %s has no name", label, ia.RepositoryUrl))
+	}
+	if strings.TrimSpace(ia.RepositoryUrl) == "" {
+		problems = append(problems, fmt.Sprintf("%s: Application %s has no repositoryUrl", label, ia.Name))
+	}
+
+	a := Application{Name: ia.Name, RepositoryUrl: ia.RepositoryUrl, ScmId: ia.ScmId}
+	if ia.DefaultBranch != "" {
+		defaultBranch := ia.DefaultBranch
+		a.DefaultBranch = &defaultBranch
+	}
+	return a, problems
+}
@@ -0,0 +1,136 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeManifest(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestManifestYaml(t *testing.T) {
+	scm := NewManifestScmIntegration(writeManifest(t, "inventory.yaml", `
+organizations:
+  - name: Mercurial
+    organizations:
+      - name: Tools
+        applications:
+          - name: Build Scripts
+            repositoryUrl: https://hg.example.com/tools/build-scripts
+            defaultBranch: default
+  - name: acme
+    scmProvider: github
+    applyScmConfiguration: true
+    applications:
+      - name: widget
+        repositoryUrl: https://github.com/acme/widget
+`), "token")
+
+	orgContents, err := scm.GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	organizations, applications := orgContents.Count()
+	assert.Equal(t, 3, organizations)
+	assert.Equal(t, 2, applications)
+
+	tools := orgContents.Organizations[0].SubOrganizations[0]
+	assert.Equal(t, "build-scripts", tools.Applications[0].SafeId())
+	assert.Equal(t, "default", *tools.Applications[0].DefaultBranch)
+	assert.Empty(t, tools.ScmProvider)
+	assert.Equal(t, &ScmConfiguration{Type: SCM_TYPE_GITHUB, Password: "token"}, scm.GetScmConfig())
+}
+
+func TestManifestCsvRoundTrip(t *testing.T) {
+	var exported bytes.Buffer
+	assert.NoError(t, inventoryTestContents().Export(&exported, INVENTORY_FORMAT_CSV))
+
+	scm := NewManifestScmIntegration(writeManifest(t, "inventory.csv", exported.String()), "")
+	orgContents, err := scm.GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	assert.Nil(t, scm.GetScmConfig(), "no SCM configuration without a token")
+
+	acme := orgContents.Organizations[0]
+	assert.Equal(t, "acme", acme.Name)
+	assert.Equal(t, SCM_TYPE_AZURE, acme.ScmProvider)
+	assert.True(t, acme.ApplyScmConfiguration)
+	platform := acme.SubOrganizations[0]
+	assert.Equal(t, "Platform Team", platform.Name)
+	assert.False(t, platform.ApplyScmConfiguration)
+	assert.Equal(t, "api gateway", platform.Applications[0].Name)
+	assert.Equal(t, "main", *platform.Applications[0].DefaultBranch)
+	assert.Equal(t, "5febef5a-833d-4e14-b9c0-14cb638f91e6", platform.Applications[0].ScmId)
+	assert.Equal(t, "repository is disabled", platform.SkippedApplications[0].Reason)
+}
+
+func TestManifestCsvColumnsInAnyOrder(t *testing.T) {
+	scm := NewManifestScmIntegration(writeManifest(t, "inventory.csv", `repositoryUrl,organizationPath,applicationName,notes
+https://svn.example.com/web,Legacy/Web,site,owned by the web team
+https://svn.example.com/api,Legacy/Web,api,
+`), "")
+	orgContents, err := scm.GetMappedAsOrgContents()
+	assert.NoError(t, err)
+	assert.Len(t, orgContents.Organizations, 1)
+	web := orgContents.Organizations[0].SubOrganizations[0]
+	assert.Len(t, web.Applications, 2)
+	assert.Nil(t, web.Applications[0].DefaultBranch)
+}
+
+func TestManifestInvalid(t *testing.T) {
+	_, err := NewManifestScmIntegration(writeManifest(t, "inventory.csv", "organizationPath,applicationName\nacme,widget\n"), "").GetMappedAsOrgContents()
+	assert.ErrorContains(t, err, "the repositoryUrl column is missing")
+
+	_, err = NewManifestScmIntegration(writeManifest(t, "inventory.json", `{"organizations": [
+  {"name": "acme", "scmProvider": "svn", "applications": [{"name": "", "repositoryUrl": "https://svn.example.com/a"}, {"name": "b"}]},
+  {"name": "widgets", "applyScmConfiguration": true}
+]}`), "").GetMappedAsOrgContents()
+	for _, problem := range []string{
+		"acme: scmProvider 'svn' must be one of azure, bitbucket, github, gitlab",
+		"acme: the Application for https://svn.example.com/a has no name",
+		"acme: Application b has no repositoryUrl",
+		"widgets: scmProvider must be set to apply SCM configuration",
+	} {
+		assert.ErrorContains(t, err, problem)
+	}
+
+	_, err = NewManifestScmIntegration(writeManifest(t, "inventory.yaml", "organizations:\n  - name: acme\n    scm: github\n"), "").GetMappedAsOrgContents()
+	assert.ErrorContains(t, err, "field scm not found")
+}
+
+func TestManifestMixedProvidersWithToken(t *testing.T) {
+	_, err := NewManifestScmIntegration(writeManifest(t, "inventory.csv", `organizationPath,applicationName,repositoryUrl,scmProvider
+acme,widget,https://github.com/acme/widget,github
+widgets,gadget,https://gitlab.com/widgets/gadget,gitlab
+`), "token").GetMappedAsOrgContents()
+	assert.ErrorContains(t, err, "SCM configuration can only be set for one scmProvider but Organizations are for github, gitlab")
+}
+
+func TestManifestValidateConnection(t *testing.T) {
+	checks := NewManifestScmIntegration(writeManifest(t, "inventory.csv", "organizationPath,applicationName,repositoryUrl\nacme,widget,https://github.com/acme/widget\n"), "").ValidateConnection()
+	assert.True(t, checks.Passed())
+	assert.Equal(t, "1 Organizations: acme", checks.Checks[1].Detail)
+
+	checks = NewManifestScmIntegration(filepath.Join(t.TempDir(), "missing.csv"), "").ValidateConnection()
+	assert.False(t, checks.Passed())
+}
@@ -0,0 +1,277 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	NAMING_FIELD_ORGANIZATION_NAME = "organizationName"
+	NAMING_FIELD_APPLICATION_NAME  = "applicationName"
+	NAMING_FIELD_APPLICATION_ID    = "applicationId"
+)
+
+var (
+	NAMING_FIELDS = []string{NAMING_FIELD_ORGANIZATION_NAME, NAMING_FIELD_APPLICATION_NAME, NAMING_FIELD_APPLICATION_ID}
+
+	namingTemplateFuncs = template.FuncMap{
+		"lower":    strings.ToLower,
+		"upper":    strings.ToUpper,
+		"replace":  func(old string, new string, in string) string { return strings.ReplaceAll(in, old, new) },
+		"safeName": safeName,
+		"safeId":   safeId,
+	}
+)
+
+/**
+ * Naming decides the names (and IDs) Organizations and Applications are created with in Sonatype Lifecycle,
+ * in place of their safe names. Each is a Go template (see text/template) of NamingFields - e.g.
+ * `ado-{{.Project | safeId}}-{{.Repo | safeId}}` - after which the Rewrites are applied in turn.
+ */
+type Naming struct {
+	OrganizationName string        `json:"organizationName,omitempty" yaml:"organizationName,omitempty"`
+	ApplicationName  string        `json:"applicationName,omitempty" yaml:"applicationName,omitempty"`
+	ApplicationId    string        `json:"applicationId,omitempty" yaml:"applicationId,omitempty"`
+	Rewrites         []RewriteRule `json:"rewrites,omitempty" yaml:"rewrites,omitempty"`
+}
+
+// RewriteRule replaces every match of a regular expression in a name or ID - Replace may refer to groups as $1.
+type RewriteRule struct {
+	// Field is the name or ID rewritten - organizationName, applicationName or applicationId - or all of them when empty
+	Field   string `json:"field,omitempty" yaml:"field,omitempty"`
+	Pattern string `json:"pattern" yaml:"pattern"`
+	Replace string `json:"replace" yaml:"replace"`
+}
+
+// NamingFields are what a Naming template can refer to.
+type NamingFields struct {
+	// Account is the top level Organization - e.g. the Azure DevOps Organization or GitHub Organization
+	Account string
+	// Project is the Organization beneath the Account - e.g. the Azure DevOps or Bitbucket Project
+	Project string
+	// Organization is the Organization being named, or that the Application is in
+	Organization  string
+	Repo          string
+	RepositoryUrl string
+	Provider      string
+}
+
+func (n *Naming) IsEmpty() bool {
+	return n.OrganizationName == "" && n.ApplicationName == "" && n.ApplicationId == "" && len(n.Rewrites) == 0
+}
+
+// Merge returns Naming using the templates of other where set, else these - and the Rewrites of both.
+func (n Naming) Merge(other Naming) Naming {
+	merged := n
+	if other.OrganizationName != "" {
+		merged.OrganizationName = other.OrganizationName
+	}
+	if other.ApplicationName != "" {
+		merged.ApplicationName = other.ApplicationName
+	}
+	if other.ApplicationId != "" {
+		merged.ApplicationId = other.ApplicationId
+	}
+	merged.Rewrites = append(append([]RewriteRule{}, n.Rewrites...), other.Rewrites...)
+	return merged
+}
+
+// Validate returns a description of each template or rewrite rule that is malformed.
+func (n *Naming) Validate() []string {
+	problems := make([]string, 0)
+	for _, t := range []struct{ field, text string }{
+		{NAMING_FIELD_ORGANIZATION_NAME, n.OrganizationName},
+		{NAMING_FIELD_APPLICATION_NAME, n.ApplicationName},
+		{NAMING_FIELD_APPLICATION_ID, n.ApplicationId},
+	} {
+		if _, err := parseNamingTemplate(t.field, t.text); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: malformed template '%s': %v", t.field, t.text, err))
+		}
+	}
+	for i, r := range n.Rewrites {
+		if r.Field != "" && r.Field != NAMING_FIELD_ORGANIZATION_NAME && r.Field != NAMING_FIELD_APPLICATION_NAME && r.Field != NAMING_FIELD_APPLICATION_ID {
+			problems = append(problems, fmt.Sprintf("rewrites[%d].field: must be one of %s", i, strings.Join(NAMING_FIELDS, ", ")))
+		}
+		if r.Pattern == "" {
+			problems = append(problems, fmt.Sprintf("rewrites[%d].pattern: must be set", i))
+		} else if _, err := regexp.Compile(r.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("rewrites[%d].pattern: malformed pattern '%s': %v", i, r.Pattern, err))
+		}
+	}
+	return problems
+}
+
+/**
+ * ApplyNaming returns the OrgContents with every Organization and Application named by the Naming. Every
+ * name or ID that is empty, or has characters Sonatype Lifecycle does not permit, is reported at once.
+ */
+func (oc *OrgContents) ApplyNaming(n Naming) (*OrgContents, error) {
+	if n.IsEmpty() {
+		return oc, nil
+	}
+
+	namer, err := newNamer(n)
+	if err != nil {
+		return nil, err
+	}
+	named := &OrgContents{Organizations: make([]Organization, 0, len(oc.Organizations))}
+	for _, o := range oc.Organizations {
+		named.Organizations = append(named.Organizations, namer.nameOrganization(o, []string{}))
+	}
+	if len(namer.problems) > 0 {
+		return nil, fmt.Errorf("naming produced names or IDs that cannot be used:\n  - %s", strings.Join(namer.problems, "\n  - "))
+	}
+	return named, nil
+}
+
+type namer struct {
+	templates map[string]*template.Template
+	rewrites  []RewriteRule
+	patterns  []*regexp.Regexp
+	problems  []string
+}
+
+func newNamer(n Naming) (*namer, error) {
+	if problems := n.Validate(); len(problems) > 0 {
+		return nil, fmt.Errorf("naming is not valid: %s", strings.Join(problems, "; "))
+	}
+
+	nm := &namer{templates: make(map[string]*template.Template), rewrites: n.Rewrites, problems: make([]string, 0)}
+	for field, text := range map[string]string{
+		NAMING_FIELD_ORGANIZATION_NAME: n.OrganizationName,
+		NAMING_FIELD_APPLICATION_NAME:  n.ApplicationName,
+		NAMING_FIELD_APPLICATION_ID:    n.ApplicationId,
+	} {
+		nm.templates[field], _ = parseNamingTemplate(field, text)
+	}
+	for _, r := range n.Rewrites {
+		nm.patterns = append(nm.patterns, regexp.MustCompile(r.Pattern))
+	}
+	return nm, nil
+}
+
+func (nm *namer) nameOrganization(o Organization, parents []string) Organization {
+	orgPath := append(parents[:len(parents):len(parents)], o.Name)
+	fields := namingFields(orgPath, o.ScmProvider)
+	label := fmt.Sprintf("Organization %s", strings.Join(orgPath, INVENTORY_PATH_SEPARATOR))
+
+	named := o
+	named.iqName = nm.name(NAMING_FIELD_ORGANIZATION_NAME, fields, o.SafeName(), label)
+	named.Applications = make([]Application, 0, len(o.Applications))
+	for _, a := range o.Applications {
+		named.Applications = append(named.Applications, nm.nameApplication(a, fields, label))
+	}
+	named.SubOrganizations = make([]Organization, 0, len(o.SubOrganizations))
+	for _, so := range o.SubOrganizations {
+		named.SubOrganizations = append(named.SubOrganizations, nm.nameOrganization(so, orgPath))
+	}
+	return named
+}
+
+func (nm *namer) nameApplication(a Application, orgFields NamingFields, orgLabel string) Application {
+	fields := orgFields
+	fields.Repo = a.Name
+	fields.RepositoryUrl = a.RepositoryUrl
+	label := fmt.Sprintf("Application %s in %s", a.Name, orgLabel)
+
+	named := a
+	named.iqName = nm.name(NAMING_FIELD_APPLICATION_NAME, fields, a.SafeName(), label)
+	named.iqId = nm.name(NAMING_FIELD_APPLICATION_ID, fields, a.SafeId(), label)
+	return named
+}
+
+// name renders the template for a field (or takes the safe name if there is none), then applies the rewrites,
+// recording a problem if the result is not permitted.
+func (nm *namer) name(field string, fields NamingFields, safe string, label string) string {
+	value := safe
+	if t := nm.templates[field]; t != nil {
+		var b strings.Builder
+		if err := t.Execute(&b, fields); err != nil {
+			nm.problems = append(nm.problems, fmt.Sprintf("%s: %s template failed: %v", label, field, err))
+			return safe
+		}
+		value = b.String()
+	}
+	for i, r := range nm.rewrites {
+		if r.Field == "" || r.Field == field {
+			value = nm.patterns[i].ReplaceAllString(value, r.Replace)
+		}
+	}
+	value = strings.TrimSpace(value)
+
+	banned := BANNED_CHARS_NAME
+	if field == NAMING_FIELD_APPLICATION_ID {
+		banned = BANNED_CHARS_ID
+	}
+	if value == "" {
+		nm.problems = append(nm.problems, fmt.Sprintf("%s: %s is empty", label, field))
+	} else if strings.ContainsAny(value, banned) {
+		nm.problems = append(nm.problems, fmt.Sprintf("%s: %s '%s' contains characters that are not permitted (any of %s)", label, field, value, banned))
+	}
+	return value
+}
+
+func namingFields(orgPath []string, provider string) NamingFields {
+	fields := NamingFields{Account: orgPath[0], Organization: orgPath[len(orgPath)-1], Provider: provider}
+	if len(orgPath) > 1 {
+		fields.Project = orgPath[1]
+	}
+	return fields
+}
+
+// parseNamingTemplate parses a template, checking it only refers to NamingFields - nil when text is empty.
+func parseNamingTemplate(field string, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(field).Funcs(namingTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(&strings.Builder{}, NamingFields{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// UseStableIds returns the OrgContents with every Application to be created with its StableId.
+func (oc *OrgContents) UseStableIds() *OrgContents {
+	var useStableIds func(o Organization) Organization
+	useStableIds = func(o Organization) Organization {
+		updated := o
+		updated.Applications = make([]Application, 0, len(o.Applications))
+		for _, a := range o.Applications {
+			a.iqId = a.StableId()
+			updated.Applications = append(updated.Applications, a)
+		}
+		updated.SubOrganizations = make([]Organization, 0, len(o.SubOrganizations))
+		for _, so := range o.SubOrganizations {
+			updated.SubOrganizations = append(updated.SubOrganizations, useStableIds(so))
+		}
+		return updated
+	}
+
+	updated := &OrgContents{Organizations: make([]Organization, 0, len(oc.Organizations))}
+	for _, o := range oc.Organizations {
+		updated.Organizations = append(updated.Organizations, useStableIds(o))
+	}
+	return updated
+}
@@ -0,0 +1,100 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func namingTestContents() *OrgContents {
+	return &OrgContents{
+		Organizations: []Organization{
+			{
+				Name:        "acme",
+				ScmProvider: SCM_TYPE_AZURE,
+				SubOrganizations: []Organization{
+					{
+						Name:         "Platform Team",
+						ScmProvider:  SCM_TYPE_AZURE,
+						Applications: []Application{{Name: "API Gateway", RepositoryUrl: "https://dev.azure.com/acme/Platform%20Team/_git/API%20Gateway"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestApplyNaming(t *testing.T) {
+	named, err := namingTestContents().ApplyNaming(Naming{
+		OrganizationName: "{{.Organization | upper}}",
+		ApplicationId:    "ado-{{.Project | safeId}}-{{.Repo | safeId}}",
+		Rewrites: []RewriteRule{
+			{Field: NAMING_FIELD_APPLICATION_NAME, Pattern: `^API\s+`, Replace: "api-"},
+			{Pattern: "TEAM", Replace: "Squad"},
+		},
+	})
+	assert.NoError(t, err)
+
+	acme := named.Organizations[0]
+	assert.Equal(t, "ACME", acme.SafeName())
+	platform := acme.SubOrganizations[0]
+	assert.Equal(t, "PLATFORM Squad", platform.SafeName())
+	assert.Equal(t, "Platform Team", platform.Name, "the SCM name is kept")
+	app := platform.Applications[0]
+	assert.Equal(t, "api-Gateway", app.SafeName())
+	assert.Equal(t, "ado-platform-team-api-gateway", app.SafeId())
+
+	// The original is left untouched
+	assert.Equal(t, "api-gateway", namingTestContents().Organizations[0].SubOrganizations[0].Applications[0].SafeId())
+}
+
+func TestApplyNamingReportsNamesNotPermitted(t *testing.T) {
+	_, err := namingTestContents().ApplyNaming(Naming{
+		ApplicationName: "{{.Account}}/{{.Repo}} (azure)",
+		ApplicationId:   "{{.Provider}}_{{.Repo}}",
+	})
+	assert.ErrorContains(t, err, "Application API Gateway in Organization acme/Platform Team: applicationName 'acme/API Gateway (azure)' contains characters that are not permitted")
+	assert.ErrorContains(t, err, "Application API Gateway in Organization acme/Platform Team: applicationId 'azure_API Gateway' contains characters that are not permitted")
+
+	_, err = namingTestContents().ApplyNaming(Naming{OrganizationName: "{{.Project}}"})
+	assert.ErrorContains(t, err, "Organization acme: organizationName is empty")
+}
+
+func TestNamingValidate(t *testing.T) {
+	n := Naming{
+		OrganizationName: "{{.Acount}}",
+		ApplicationName:  "{{.Repo",
+		ApplicationId:    "{{.Repo | safeId}}",
+		Rewrites:         []RewriteRule{{Field: "name", Pattern: "(abc"}, {}},
+	}
+	problems := n.Validate()
+	assert.Len(t, problems, 5)
+	assert.Contains(t, problems[0], "organizationName: malformed template '{{.Acount}}'")
+	assert.Contains(t, problems[1], "applicationName: malformed template '{{.Repo'")
+	assert.Equal(t, "rewrites[0].field: must be one of organizationName, applicationName, applicationId", problems[2])
+	assert.Contains(t, problems[3], "rewrites[0].pattern: malformed pattern '(abc'")
+	assert.Equal(t, "rewrites[1].pattern: must be set", problems[4])
+}
+
+func TestNamingMerge(t *testing.T) {
+	merged := Naming{OrganizationName: "a", ApplicationId: "b", Rewrites: []RewriteRule{{Pattern: "x"}}}.Merge(Naming{ApplicationId: "c", Rewrites: []RewriteRule{{Pattern: "y"}}})
+	assert.Equal(t, "a", merged.OrganizationName)
+	assert.Equal(t, "c", merged.ApplicationId)
+	assert.Len(t, merged.Rewrites, 2)
+}
@@ -16,8 +16,25 @@
 
 package scm
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
 type SCMIntegration interface {
 	GetMappedAsOrgContents() (*OrgContents, error)
 	GetScmConfig() *ScmConfiguration
-	ValidateConnection() (bool, error)
+	// ValidateConnection checks the credentials can authenticate, list the top level Organizations they can
+	// reach and read what is within each - without loading everything
+	ValidateConnection() *util.Checklist
+}
+
+// describeNames lists names found by a check, e.g. "2 Workspaces: acme, widgets".
+func describeNames(kind string, names []string) string {
+	if len(names) == 0 {
+		return fmt.Sprintf("no %s", kind)
+	}
+	return fmt.Sprintf("%d %s: %s", len(names), kind, strings.Join(names, ", "))
 }
@@ -17,6 +17,8 @@
 package scm
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"regexp"
@@ -26,9 +28,16 @@ import (
 )
 
 const (
-	BANNED_CHARS_ID   = ";$!&|()[]<> _#"
-	BANNED_CHARS_NAME = ";$!&|()[]<>"
-	SCM_TYPE_AZURE    = "azure"
+	BANNED_CHARS_ID    = ";$!&|()[]<> _#"
+	BANNED_CHARS_NAME  = ";$!&|()[]<>"
+	SCM_TYPE_AZURE     = "azure"
+	SCM_TYPE_BITBUCKET = "bitbucket"
+	SCM_TYPE_GITHUB    = "github"
+	SCM_TYPE_GITLAB    = "gitlab"
+	// STABLE_ID_PREFIX starts every ID returned by Application.StableId
+	STABLE_ID_PREFIX = "repo-"
+	// STABLE_ID_HASH_LENGTH is the number of hex characters of the Repository URL hash used in a StableId
+	STABLE_ID_HASH_LENGTH = 20
 )
 
 var (
@@ -47,17 +56,49 @@ type Application struct {
 	Name          string
 	DefaultBranch *string
 	RepositoryUrl string
+	// ScmId is the immutable identity of the Repository in the SCM, where it has one - e.g. the Azure DevOps
+	// or Bitbucket Cloud Repository UUID. Numeric IDs are only unique within one server, so are qualified
+	// with the SCM type and host (see numericScmId)
+	ScmId string
+	// iqName and iqId are set by ApplyNaming in place of the safe name and ID
+	iqName string
+	iqId   string
+}
+
+// SkippedApplication is a Repository the SCM integration left out, and why.
+type SkippedApplication struct {
+	Application
+	Reason string
 }
 
 func (a *Application) PrintTree(depth int) {
-	println(fmt.Sprintf("%sAPP: %s (to be created as %s)", strings.Repeat(" -- ", depth), a.Name, a.SafeName()))
+	println(fmt.Sprintf("%sAPP: %s (to be created as %s with ID %s)", strings.Repeat(" -- ", depth), a.Name, a.SafeName(), a.SafeId()))
 }
 
 func (a *Application) SafeId() string {
-	return strings.ToLower(strings.ReplaceAll(safeName(a.Name), " ", "-"))
+	if a.iqId != "" {
+		return a.iqId
+	}
+	return safeId(a.Name)
+}
+
+/**
+ * StableId is a public ID derived from the immutable identity of the Repository rather than its name - its
+ * ScmId where it has one, else a hash of its normalized Repository URL - so it is the same on every run,
+ * whatever order Applications are created in.
+ */
+func (a *Application) StableId() string {
+	if a.ScmId != "" {
+		return STABLE_ID_PREFIX + safeId(a.ScmId)
+	}
+	hash := sha256.Sum256([]byte(NormalizeRepositoryUrl(a.RepositoryUrl)))
+	return STABLE_ID_PREFIX + hex.EncodeToString(hash[:])[:STABLE_ID_HASH_LENGTH]
 }
 
 func (a *Application) SafeName() string {
+	if a.iqName != "" {
+		return a.iqName
+	}
 	return safeName(a.Name)
 }
 
@@ -88,24 +129,80 @@ func safeRepositoryUrl(in string) bool {
 	return !INVALID_BRANCH_NAME.MatchString(decoded)
 }
 
+/**
+ * Reduces a Repository URL to a form that can be compared with another, regardless of protocol,
+ * credentials, letter case or a trailing `.git` or `/` - e.g. `https://user@GitHub.com/Acme/Repo.git`
+ * and `git@github.com:acme/repo` both normalize to `github.com/acme/repo`.
+ */
+/**
+ * Qualifies a numeric Repository ID with the SCM type and the host of the server it came from - e.g.
+ * github-github.com-1296269 - as github.com and GitHub Enterprise Server (or two GitLab or Bitbucket Server
+ * instances) number their Repositories independently.
+ */
+func numericScmId(scmType string, repositoryUrl string, id int64) string {
+	host, _, _ := strings.Cut(NormalizeRepositoryUrl(repositoryUrl), "/")
+	return fmt.Sprintf("%s-%s-%d", scmType, host, id)
+}
+
+func NormalizeRepositoryUrl(in string) string {
+	u := strings.TrimSpace(in)
+	if u == "" {
+		return ""
+	}
+
+	// SCP-like SSH syntax, e.g. git@github.com:acme/repo.git
+	if !strings.Contains(u, "://") {
+		if i := strings.Index(u, ":"); i > 0 {
+			u = fmt.Sprintf("ssh://%s/%s", u[:i], strings.TrimLeft(u[i+1:], "/"))
+		}
+	}
+
+	host, path := u, ""
+	parsed, err := url.Parse(u)
+	if err == nil && parsed.Host != "" {
+		host, path = parsed.Hostname(), parsed.Path
+	}
+
+	normalized := strings.TrimRight(host+path, "/")
+	normalized = strings.TrimSuffix(normalized, ".git")
+	return strings.ToLower(strings.TrimRight(normalized, "/"))
+}
+
 type Organization struct {
-	Name             string
-	ScmProvider      string
-	Applications     []Application
-	SubOrganizations []Organization
+	Name        string
+	ScmProvider string
+	// ApplyScmConfiguration is set by the SCM integration on the Organization(s) that should hold the SCM
+	// configuration in Sonatype - Organizations beneath inherit it
+	ApplyScmConfiguration bool
+	Applications          []Application
+	// SkippedApplications are Repositories deliberately not onboarded - listed so the preview shows why
+	SkippedApplications []SkippedApplication
+	SubOrganizations    []Organization
+	// iqName is set by ApplyNaming in place of the safe name
+	iqName string
 }
 
 func (o *Organization) PrintTree(depth int) {
-	println(fmt.Sprintf("%sORG: %s (to be created as %s)", strings.Repeat(" -- ", depth), o.Name, o.SafeName()))
+	scmNote := ""
+	if o.ApplyScmConfiguration {
+		scmNote = fmt.Sprintf(" [%s SCM configuration]", o.ScmProvider)
+	}
+	println(fmt.Sprintf("%sORG: %s (to be created as %s)%s", strings.Repeat(" -- ", depth), o.Name, o.SafeName(), scmNote))
 	for _, a := range o.Applications {
 		a.PrintTree((depth + 1))
 	}
+	for _, a := range o.SkippedApplications {
+		println(fmt.Sprintf("%sSKIPPED APP: %s - %s", strings.Repeat(" -- ", depth+1), a.Name, a.Reason))
+	}
 	for _, so := range o.SubOrganizations {
 		so.PrintTree((depth + 1))
 	}
 }
 
 func (o *Organization) SafeName() string {
+	if o.iqName != "" {
+		return o.iqName
+	}
 	return safeName(o.Name)
 }
 
@@ -113,6 +210,23 @@ type OrgContents struct {
 	Organizations []Organization
 }
 
+// Count returns the number of Organizations and Applications, at every depth.
+func (oc *OrgContents) Count() (int, int) {
+	organizations, applications := 0, 0
+	var count func(o Organization)
+	count = func(o Organization) {
+		organizations++
+		applications += len(o.Applications)
+		for _, so := range o.SubOrganizations {
+			count(so)
+		}
+	}
+	for _, o := range oc.Organizations {
+		count(o)
+	}
+	return organizations, applications
+}
+
 func (oc *OrgContents) PrintTree() {
 	depth := 0
 	for _, o := range oc.Organizations {
@@ -129,3 +243,7 @@ func safeName(in string) string {
 		"-",
 	)
 }
+
+func safeId(in string) string {
+	return strings.ToLower(strings.ReplaceAll(safeName(in), " ", "-"))
+}
@@ -0,0 +1,71 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+)
+
+// Check is the outcome of a single check, with what was found (or what went wrong) as its Detail.
+type Check struct {
+	Description string
+	Passed      bool
+	Detail      string
+}
+
+// Checklist collects the outcomes of a series of checks, to be printed as a pass/fail list.
+type Checklist struct {
+	Checks []Check
+}
+
+func NewChecklist() *Checklist {
+	return &Checklist{Checks: make([]Check, 0)}
+}
+
+// Check records a check that passed (with detail) if err is nil, or failed with err, and reports which.
+func (c *Checklist) Check(description string, detail string, err error) bool {
+	if err != nil {
+		c.Checks = append(c.Checks, Check{Description: description, Detail: err.Error()})
+		return false
+	}
+	c.Checks = append(c.Checks, Check{Description: description, Passed: true, Detail: detail})
+	return true
+}
+
+// Passed reports whether every check passed.
+func (c *Checklist) Passed() bool {
+	for _, check := range c.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Checklist) Print() {
+	for _, check := range c.Checks {
+		symbol := "✅"
+		if !check.Passed {
+			symbol = "❌"
+		}
+		line := fmt.Sprintf("  %s %s", symbol, check.Description)
+		if check.Detail != "" {
+			line = fmt.Sprintf("%s - %s", line, check.Detail)
+		}
+		println(line)
+	}
+}
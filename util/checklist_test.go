@@ -0,0 +1,35 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecklist(t *testing.T) {
+	checks := NewChecklist()
+	assert.True(t, checks.Check("Authenticate", "as admin", nil))
+	assert.True(t, checks.Passed())
+
+	assert.False(t, checks.Check("Read Repositories", "ignored", errors.New("403 Forbidden")))
+	assert.False(t, checks.Passed())
+	assert.Equal(t, Check{Description: "Read Repositories", Detail: "403 Forbidden"}, checks.Checks[1])
+	assert.Equal(t, "as admin", checks.Checks[0].Detail)
+}
@@ -0,0 +1,247 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_RETRY_MAX_ATTEMPTS = 5
+	DEFAULT_RETRY_BASE_DELAY   = 500 * time.Millisecond
+	DEFAULT_RETRY_MAX_DELAY    = 30 * time.Second
+	// MAX_RETRY_AFTER caps how long we will wait when a server asks us to (via Retry-After or X-RateLimit-Reset)
+	MAX_RETRY_AFTER = 5 * time.Minute
+)
+
+/**
+ * Governs how failed HTTP requests are retried: with exponential backoff and jitter, unless the server
+ * says how long to wait via `Retry-After`, or `X-RateLimit-Remaining: 0` and `X-RateLimit-Reset`.
+ *
+ * Idempotent requests are retried on 429, 5xx and network errors. Other requests (e.g. POST, which
+ * creates things) are only retried where the server cannot have acted on them - 429, 503 or a refused
+ * connection - so that nothing is ever created twice. A 403 carrying `Retry-After` or
+ * `X-RateLimit-Remaining: 0` is how GitHub signals its rate limits, so is treated as a 429.
+ */
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used for all requests to Sonatype Lifecycle and every SCM.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: DEFAULT_RETRY_MAX_ATTEMPTS,
+	BaseDelay:   DEFAULT_RETRY_BASE_DELAY,
+	MaxDelay:    DEFAULT_RETRY_MAX_DELAY,
+}
+
+var (
+	// A copy of the default transport taken before UseRetryingDefaultTransport() can replace it
+	baseTransport               http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+	useRetryingDefaultTransport sync.Once
+)
+
+// NewRetryingHttpClient returns an HTTP client that retries according to the supplied policy.
+func NewRetryingHttpClient(policy *RetryPolicy) *http.Client {
+	return &http.Client{
+		Transport: NewRetryTransport(baseTransport, policy),
+	}
+}
+
+/**
+ * Makes every HTTP client that relies on http.DefaultTransport retry according to DefaultRetryPolicy.
+ *
+ * This is only needed for SDKs that create their own HTTP clients and offer no way to supply one
+ * (Azure DevOps) - clients we create ourselves should use NewRetryingHttpClient().
+ */
+func UseRetryingDefaultTransport() {
+	useRetryingDefaultTransport.Do(func() {
+		http.DefaultTransport = NewRetryTransport(baseTransport, DefaultRetryPolicy)
+	})
+}
+
+type RetryTransport struct {
+	next   http.RoundTripper
+	policy *RetryPolicy
+
+	// Once a server has asked us to back off, all requests through this transport wait
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+func NewRetryTransport(next http.RoundTripper, policy *RetryPolicy) *RetryTransport {
+	return &RetryTransport{
+		next:   next,
+		policy: policy,
+	}
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.GetBody == nil {
+		// Buffer the body so that it can be sent again
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+		req.Body, _ = req.GetBody()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := t.waitForPause(req)
+		if err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 1 {
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				attemptReq.Body, err = req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		serverDelay := serverRequestedDelay(resp)
+		if serverDelay > 0 {
+			t.pause(serverDelay)
+		}
+
+		reason, retryable := retryReason(req.Method, resp, err)
+		if !retryable || attempt >= t.policy.MaxAttempts || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+
+		delay := serverDelay
+		if delay == 0 {
+			delay = t.policy.backoff(attempt)
+		}
+		log.Warn(fmt.Sprintf("%s %s failed (%s) - retrying in %s (attempt %d of %d)", req.Method, req.URL.Redacted(), reason, delay.Round(time.Millisecond), attempt+1, t.policy.MaxAttempts))
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func (t *RetryTransport) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.pauseUntil) {
+		t.pauseUntil = until
+	}
+}
+
+func (t *RetryTransport) waitForPause(req *http.Request) error {
+	t.mu.Lock()
+	wait := time.Until(t.pauseUntil)
+	t.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	log.Debug(fmt.Sprintf("Waiting %s before %s %s as requested by the server", wait.Round(time.Millisecond), req.Method, req.URL.Redacted()))
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// backoff returns an exponentially increasing delay with jitter for the given (1-based) attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	// Anywhere between half and all of the delay, so parallel workers do not retry in lock-step
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func retryReason(method string, resp *http.Response, err error) (string, bool) {
+	idempotent := method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == http.MethodPut || method == http.MethodDelete
+
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return err.Error(), true
+		}
+		return err.Error(), idempotent
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		return resp.Status, true
+	case resp.StatusCode == http.StatusForbidden && (resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"):
+		return fmt.Sprintf("%s - rate limited", resp.Status), true
+	case resp.StatusCode >= 500:
+		return resp.Status, idempotent
+	}
+	return "", false
+}
+
+// serverRequestedDelay returns how long the server has asked us to wait before sending further requests, if at all.
+func serverRequestedDelay(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+
+	var d time.Duration
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			d = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(retryAfter); err == nil {
+			d = time.Until(at)
+		}
+	} else if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			d = time.Until(time.Unix(reset, 0))
+		}
+	}
+
+	if d < 0 {
+		return 0
+	}
+	if d > MAX_RETRY_AFTER {
+		return MAX_RETRY_AFTER
+	}
+	return d
+}
@@ -0,0 +1,160 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRetryClient(maxAttempts int) *http.Client {
+	return NewRetryingHttpClient(&RetryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+	})
+}
+
+// failingServer fails the first `failures` requests with `status`, then succeeds echoing the request body.
+func failingServer(failures int32, status int, headers map[string]string) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			for k, v := range headers {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	}))
+	return server, &requests
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	server, requests := failingServer(2, http.StatusBadGateway, nil)
+	defer server.Close()
+
+	resp, err := newTestRetryClient(5).Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	server, requests := failingServer(10, http.StatusInternalServerError, nil)
+	defer server.Close()
+
+	resp, err := newTestRetryClient(3).Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestRetryResendsBodyOnRateLimit(t *testing.T) {
+	server, requests := failingServer(1, http.StatusTooManyRequests, map[string]string{"Retry-After": "0"})
+	defer server.Close()
+
+	resp, err := newTestRetryClient(3).Post(server.URL, "application/json", strings.NewReader(`{"name":"widget"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `POST {"name":"widget"}`, string(body))
+}
+
+func TestRetryOnGitHubRateLimit(t *testing.T) {
+	// Secondary rate limit
+	server, requests := failingServer(1, http.StatusForbidden, map[string]string{"Retry-After": "0"})
+	defer server.Close()
+
+	resp, err := newTestRetryClient(3).Post(server.URL, "application/json", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(requests))
+
+	// Primary rate limit, reset already passed
+	reset := fmt.Sprint(time.Now().Add(-time.Second).Unix())
+	server, requests = failingServer(2, http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": reset})
+	defer server.Close()
+
+	resp, err = newTestRetryClient(3).Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+
+	// Any other 403 is a lack of permission
+	server, requests = failingServer(1, http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "4999"})
+	defer server.Close()
+
+	resp, err = newTestRetryClient(3).Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestNoRetryOfPostOnServerError(t *testing.T) {
+	server, requests := failingServer(1, http.StatusInternalServerError, nil)
+	defer server.Close()
+
+	resp, err := newTestRetryClient(3).Post(server.URL, "application/json", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestServerRequestedDelay(t *testing.T) {
+	header := func(kv ...string) *http.Response {
+		resp := &http.Response{Header: http.Header{}}
+		for i := 0; i < len(kv); i += 2 {
+			resp.Header.Set(kv[i], kv[i+1])
+		}
+		return resp
+	}
+
+	assert.Equal(t, time.Duration(0), serverRequestedDelay(nil))
+	assert.Equal(t, time.Duration(0), serverRequestedDelay(header()))
+	assert.Equal(t, 7*time.Second, serverRequestedDelay(header("Retry-After", "7")))
+	assert.Equal(t, MAX_RETRY_AFTER, serverRequestedDelay(header("Retry-After", "86400")))
+
+	reset := time.Now().Add(20 * time.Second).Unix()
+	d := serverRequestedDelay(header("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", fmt.Sprint(reset)))
+	assert.InDelta(t, 20*time.Second, d, float64(2*time.Second))
+
+	// Only wait for the reset once the limit is exhausted
+	assert.Equal(t, time.Duration(0), serverRequestedDelay(header("X-RateLimit-Remaining", "10", "X-RateLimit-Reset", fmt.Sprint(reset))))
+}
+
+func TestBackoffIsBounded(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 1; attempt < 10; attempt++ {
+		d := policy.backoff(attempt)
+		assert.Greater(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, time.Second)
+	}
+}
@@ -0,0 +1,97 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"sync"
+)
+
+/**
+ * Runs submitted jobs across a fixed number of goroutines. Once any job fails no further jobs are
+ * started, and the first error is returned by Wait().
+ *
+ * With a size of 1 (or less) jobs are run immediately by Submit(), one at a time, in order.
+ */
+type WorkerPool struct {
+	size int
+	jobs chan func() error
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	err  error
+}
+
+func NewWorkerPool(size int) *WorkerPool {
+	p := &WorkerPool{size: size}
+	if size <= 1 {
+		return p
+	}
+
+	p.jobs = make(chan func() error)
+	for i := 0; i < size; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				if p.failed() != nil {
+					continue
+				}
+				p.fail(job())
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues a job, blocking until a worker is free. It returns the first error of any job that
+// has already failed, in which case the job is not run.
+func (p *WorkerPool) Submit(job func() error) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	if p.jobs == nil {
+		p.fail(job())
+		return p.failed()
+	}
+	p.jobs <- job
+	return nil
+}
+
+// Wait waits for all submitted jobs to finish. The pool cannot be used afterwards.
+func (p *WorkerPool) Wait() error {
+	if p.jobs != nil {
+		close(p.jobs)
+		p.wg.Wait()
+	}
+	return p.failed()
+}
+
+func (p *WorkerPool) fail(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *WorkerPool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
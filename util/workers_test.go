@@ -0,0 +1,57 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPoolSequential(t *testing.T) {
+	pool := NewWorkerPool(1)
+	order := make([]int, 0)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, pool.Submit(func() error {
+			order = append(order, i)
+			return nil
+		}))
+	}
+	assert.NoError(t, pool.Wait())
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+}
+
+func TestWorkerPoolStopsOnError(t *testing.T) {
+	pool := NewWorkerPool(4)
+	var ran int32
+	var submitErr error
+	for i := 0; i < 1000 && submitErr == nil; i++ {
+		submitErr = pool.Submit(func() error {
+			atomic.AddInt32(&ran, 1)
+			if i == 10 {
+				return fmt.Errorf("job %d failed", i)
+			}
+			return nil
+		})
+	}
+
+	err := pool.Wait()
+	assert.EqualError(t, err, "job 10 failed")
+	assert.Less(t, atomic.LoadInt32(&ran), int32(1000))
+}
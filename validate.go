@@ -0,0 +1,86 @@
+/**
+ * Copyright (c) 2019-present Sonatype, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/config"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/iq"
+	"github.com/sonatype-nexus-community/sonatype-lifecycle-bulk-scm-onboarder/util"
+)
+
+/**
+ * Checks the Sonatype Lifecycle user can onboard into each of the named Organizations, and that the
+ * credentials for each source can read what is to be onboarded - printing a pass/fail checklist for each.
+ * Nothing is written to Sonatype Lifecycle unless probeWrites is set.
+ */
+func runChecks(nxiqServer *iq.NxiqServer, organizationNames []string, sources []config.Source, probeWrites bool) bool {
+	passed := true
+	for _, name := range organizationNames {
+		println(fmt.Sprintf("Sonatype Lifecycle - %s:", name))
+		checks := nxiqServer.ValidatePermissions(name, probeWrites)
+		checks.Print()
+		passed = passed && checks.Passed()
+	}
+
+	for _, source := range sources {
+		println(fmt.Sprintf("%s:", source.DisplayName()))
+		checks := util.NewChecklist()
+		scmConnection, err := newScmIntegration(source)
+		if err == nil {
+			checks = scmConnection.ValidateConnection()
+		} else {
+			checks.Check(fmt.Sprintf("Connect to %s", source.DisplayName()), "", err)
+		}
+		checks.Print()
+		passed = passed && checks.Passed()
+	}
+	println("")
+	return passed
+}
+
+// preflight runs the checks before anything is created in Sonatype Lifecycle, exiting if any fail.
+func preflight(nxiqServer *iq.NxiqServer, organizationNames []string, sources []config.Source) {
+	if skipPreflight {
+		return
+	}
+
+	println("Running pre-flight checks (skip with -skip-preflight)...")
+	if !runChecks(nxiqServer, organizationNames, sources, false) {
+		println("❌ Pre-flight checks failed - nothing has been onboarded. Fix the problems above, or re-run with -skip-preflight to proceed regardless.")
+		os.Exit(EXIT_FATAL)
+	}
+}
+
+// targetOrganizationNames lists the distinct Organizations the sources import into - or -org-name if there are none.
+func targetOrganizationNames(sources []config.Source) []string {
+	if len(sources) == 0 {
+		return []string{nxiqOrgNameToImportTo}
+	}
+
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, source := range sources {
+		if !seen[source.Organization] {
+			seen[source.Organization] = true
+			names = append(names, source.Organization)
+		}
+	}
+	return names
+}